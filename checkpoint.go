@@ -0,0 +1,159 @@
+package goseq
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint is a saved progress of a TaskManager. Cursor is the last put
+// SequenceID. Groups has LastProcessedID of each terminal HandlerGroup.
+type Checkpoint struct {
+	Cursor SequenceID
+	Groups []SequenceID
+}
+
+// Return a SequenceID which all terminal HandlerGroups have finished.
+// SequenceIDs after this value and until Cursor may not be finished.
+func (cp Checkpoint) Processed() SequenceID {
+	processedID := cp.Cursor
+	for _, id := range cp.Groups {
+		if id < processedID {
+			processedID = id
+		}
+	}
+	return processedID
+}
+
+// Checkpointer saves and loads a Checkpoint. Load returns an error
+// which matches fs.ErrNotExist when no Checkpoint is saved yet.
+type Checkpointer interface {
+	Save(checkpoint Checkpoint) error
+	Load() (Checkpoint, error)
+}
+
+type fileCheckpointer struct {
+	path string
+}
+
+// Create a Checkpointer to save a Checkpoint to a file at path. A new
+// Checkpoint is written to a temporary file and then renamed, so the file
+// always has a complete Checkpoint even if a process crashes.
+func NewFileCheckpointer(path string) Checkpointer {
+	return &fileCheckpointer{path: path}
+}
+
+func (cp *fileCheckpointer) Save(checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(cp.path)
+	file, err := os.CreateTemp(dir, filepath.Base(cp.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(file.Name(), cp.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (cp *fileCheckpointer) Load() (checkpoint Checkpoint, err error) {
+	data, err := os.ReadFile(cp.path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &checkpoint)
+	return
+}
+
+// fsync a directory to make a renamed or created entry durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+type checkpointMonitor struct {
+	checkpointer Checkpointer
+	cursor       Sequence
+	groups       func() []HandlerGroup
+	interval     time.Duration
+	onError      func(err error)
+	stopChannel  chan bool
+	waitingStop  sync.WaitGroup
+}
+
+func newCheckpointMonitor(checkpointer Checkpointer, cursor Sequence, interval time.Duration, onError func(err error)) (monitor *checkpointMonitor) {
+	monitor = new(checkpointMonitor)
+	monitor.checkpointer = checkpointer
+	monitor.cursor = cursor
+	monitor.interval = interval
+	monitor.onError = onError
+	return
+}
+
+func (monitor *checkpointMonitor) start() {
+	monitor.stopChannel = make(chan bool)
+	monitor.waitingStop.Add(1)
+	go monitor.run(monitor.groups())
+}
+
+// Stop saving periodically and then save the last Checkpoint.
+func (monitor *checkpointMonitor) stop() {
+	close(monitor.stopChannel)
+	monitor.waitingStop.Wait()
+	monitor.save(monitor.groups())
+}
+
+func (monitor *checkpointMonitor) run(groups []HandlerGroup) {
+	defer monitor.waitingStop.Done()
+	ticker := time.NewTicker(monitor.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			monitor.save(groups)
+		case <-monitor.stopChannel:
+			return
+		}
+	}
+}
+
+func (monitor *checkpointMonitor) save(groups []HandlerGroup) {
+	// Read groups before the cursor so that Cursor is never behind Groups.
+	checkpoint := Checkpoint{Groups: make([]SequenceID, len(groups))}
+	for i, group := range groups {
+		checkpoint.Groups[i] = group.LastProcessedID()
+	}
+	checkpoint.Cursor = monitor.cursor.Get()
+	if err := monitor.checkpointer.Save(checkpoint); err != nil && monitor.onError != nil {
+		monitor.onError(err)
+	}
+}
+
+func loadCheckpoint(checkpointer Checkpointer) (Checkpoint, error) {
+	checkpoint, err := checkpointer.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return Checkpoint{Cursor: initialSequenceValue}, nil
+	}
+	return checkpoint, err
+}
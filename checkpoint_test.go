@@ -0,0 +1,126 @@
+package goseq
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointProcessed(t *testing.T) {
+	cp := Checkpoint{Cursor: 10, Groups: []SequenceID{8, 5, 9}}
+	if cp.Processed() != 5 {
+		t.Error("Processed should return a minimum value of groups.")
+	}
+	cp = Checkpoint{Cursor: 3}
+	if cp.Processed() != 3 {
+		t.Error("Processed should return Cursor when there is no group.")
+	}
+}
+
+func TestFileCheckpointer(t *testing.T) {
+	dir := t.TempDir()
+	cp := NewFileCheckpointer(filepath.Join(dir, "checkpoint"))
+	if _, err := cp.Load(); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Load should return fs.ErrNotExist before saving. err:", err)
+	}
+	if err := cp.Save(Checkpoint{Cursor: 10, Groups: []SequenceID{8, 9}}); err != nil {
+		t.Error("Save failed.", err)
+	}
+	if err := cp.Save(Checkpoint{Cursor: 12, Groups: []SequenceID{11, 12}}); err != nil {
+		t.Error("Save failed.", err)
+	}
+	checkpoint, err := cp.Load()
+	if err != nil || checkpoint.Cursor != 12 || len(checkpoint.Groups) != 2 || checkpoint.Groups[0] != 11 {
+		t.Error("Load should return the last saved Checkpoint.", checkpoint, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Error("Save should not leave temporary files. entries:", len(entries))
+	}
+}
+
+func TestSetCheckpointer(t *testing.T) {
+	cp := NewFileCheckpointer(filepath.Join(t.TempDir(), "checkpoint"))
+	handler := func(id SequenceID, index int) {}
+	tm := NewTaskManager(8)
+	tm.AddHandler(handler).Then(handler)
+	tm.AddHandler(handler)
+	tm.SetCheckpointer(cp, time.Hour, func(err error) {
+		t.Error("Saving a Checkpoint failed.", err)
+	})
+	tm.Start()
+	for i := 0; i < 20; i++ {
+		tm.Put(nil)
+	}
+	tm.Stop()
+
+	checkpoint, err := cp.Load()
+	if err != nil || checkpoint.Cursor != 19 {
+		t.Error("Stop should save a Checkpoint.", checkpoint, err)
+	}
+	if len(checkpoint.Groups) != 2 || checkpoint.Processed() != 19 {
+		t.Error("Checkpoint should have terminal groups.", checkpoint.Groups)
+	}
+}
+
+func TestNewTaskManagerFromCheckpoint(t *testing.T) {
+	cp := NewFileCheckpointer(filepath.Join(t.TempDir(), "checkpoint"))
+	tm, err := NewTaskManagerFromCheckpoint(8, cp)
+	if err != nil || tm.Put(nil) != 0 {
+		t.Error("A TaskManager should start from the beginning without a Checkpoint.", err)
+	}
+
+	cp.Save(Checkpoint{Cursor: 30, Groups: []SequenceID{28}})
+	tm, err = NewTaskManagerFromCheckpoint(8, cp)
+	if err != nil {
+		t.Fatal("NewTaskManagerFromCheckpoint failed.", err)
+	}
+	count := 0
+	group := tm.AddHandler(func(id SequenceID, index int) {
+		count++
+	})
+	next := group.Then(func(id SequenceID, index int) {})
+	if group.LastProcessedID() != 30 || next.LastProcessedID() != 30 {
+		t.Error("HandlerGroups should start from the saved Cursor.")
+	}
+	tm.Start()
+	for i := 0; i < 10; i++ {
+		if id := tm.Put(nil); id != SequenceID(31+i) {
+			t.Error("Put should continue from the saved Cursor. id:", id)
+		}
+	}
+	tm.Stop()
+	if count != 10 || next.LastProcessedID() != 40 {
+		t.Error("A resumed TaskManager should process new ids.", count, next.LastProcessedID())
+	}
+}
+
+func TestReplayFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(filepath.Join(dir, "journal"), 0)
+	defer j.Close()
+	for i := 0; i < 5; i++ {
+		j.Append(SequenceID(i), []byte("value"))
+	}
+	cp := NewFileCheckpointer(filepath.Join(dir, "checkpoint"))
+	checkpoint := Checkpoint{Cursor: 4, Groups: []SequenceID{2}}
+	cp.Save(checkpoint)
+	tm, _ := NewTaskManagerFromCheckpoint(8, cp)
+	ids := make([]SequenceID, 0, 3)
+	tm.AddHandler(func(id SequenceID, index int) {
+		ids = append(ids, id)
+	})
+	tm.SetJournal(j, &testSlotCodec{slots: make([]string, 8)})
+	tm.Start()
+	if err := tm.Replay(checkpoint.Processed() + 1); err != nil {
+		t.Error("Replay failed.", err)
+	}
+	tm.Put(nil)
+	tm.Stop()
+	if len(ids) != 3 || ids[0] != 3 || ids[2] != 5 {
+		t.Error("Replay should process ids after Processed() of a Checkpoint.", ids)
+	}
+}
@@ -0,0 +1,101 @@
+package goseq
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts a value of a slot to bytes and back. Unmarshal writes a
+// value to a preallocated slot v, so a slot can be reused without
+// allocating a new value.
+type Codec[T any] interface {
+	Marshal(v *T) ([]byte, error)
+	Unmarshal(data []byte, v *T) error
+}
+
+type gobCodec[T any] struct{}
+
+// Create a Codec using encoding/gob. Unmarshal replaces a whole slot value.
+func NewGobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+func (gobCodec[T]) Marshal(v *T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec[T]) Unmarshal(data []byte, v *T) error {
+	// gob doesn't send zero values, so clear the slot first.
+	var zero T
+	*v = zero
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec[T any] struct{}
+
+// Create a Codec using encoding/json. Unmarshal replaces a whole slot value.
+func NewJSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Marshal(v *T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Unmarshal(data []byte, v *T) error {
+	var zero T
+	*v = zero
+	return json.Unmarshal(data, v)
+}
+
+type bytesCodec struct{}
+
+// Create a Codec for raw bytes. Marshal returns a slot itself without
+// copying and Unmarshal copies data to a slot reusing its capacity.
+func NewBytesCodec() Codec[[]byte] {
+	return bytesCodec{}
+}
+
+func (bytesCodec) Marshal(v *[]byte) ([]byte, error) {
+	return *v, nil
+}
+
+func (bytesCodec) Unmarshal(data []byte, v *[]byte) error {
+	*v = append((*v)[:0], data...)
+	return nil
+}
+
+type slotCodec[T any] struct {
+	codec Codec[T]
+	slots []T
+}
+
+// Create a SlotCodec for slots which are indexed by 'index' of TaskHandler.
+// The length of slots should be the size of a TaskManager. Journal,
+// spill queue and other persistent features use this to read and write
+// slots in the same way.
+func NewSlotCodec[T any](codec Codec[T], slots []T) SlotCodec {
+	return &slotCodec[T]{codec: codec, slots: slots}
+}
+
+func (sc *slotCodec[T]) Marshal(id SequenceID, index int) ([]byte, error) {
+	return sc.codec.Marshal(&sc.slots[index])
+}
+
+func (sc *slotCodec[T]) Unmarshal(id SequenceID, index int, data []byte) error {
+	return sc.codec.Unmarshal(data, &sc.slots[index])
+}
+
+// Marshal v with codec and then call TaskManager.Offer().
+func OfferValue[T any](tm TaskManager, codec Codec[T], v *T) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tm.Offer(data)
+}
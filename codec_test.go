@@ -0,0 +1,84 @@
+package goseq
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type testPayload struct {
+	Name   string
+	Values []int
+}
+
+func testCodecRoundTrip(t *testing.T, codec Codec[testPayload]) {
+	data, err := codec.Marshal(&testPayload{Name: "name", Values: []int{1, 2}})
+	if err != nil {
+		t.Fatal("Marshal failed.", err)
+	}
+	slot := testPayload{Name: "old", Values: []int{9, 9, 9}}
+	if err := codec.Unmarshal(data, &slot); err != nil {
+		t.Fatal("Unmarshal failed.", err)
+	}
+	if slot.Name != "name" || len(slot.Values) != 2 || slot.Values[1] != 2 {
+		t.Error("Unmarshal should write a value to a slot.", slot)
+	}
+	data, _ = codec.Marshal(&testPayload{})
+	codec.Unmarshal(data, &slot)
+	if slot.Name != "" || len(slot.Values) != 0 {
+		t.Error("Unmarshal should replace a whole slot value.", slot)
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	testCodecRoundTrip(t, NewGobCodec[testPayload]())
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCodecRoundTrip(t, NewJSONCodec[testPayload]())
+}
+
+func TestBytesCodec(t *testing.T) {
+	codec := NewBytesCodec()
+	slot := make([]byte, 0, 16)
+	value := []byte("value")
+	data, _ := codec.Marshal(&value)
+	codec.Unmarshal(data, &slot)
+	if string(slot) != "value" || cap(slot) != 16 {
+		t.Error("Unmarshal should copy data to a slot reusing capacity.")
+	}
+}
+
+func TestSlotCodec(t *testing.T) {
+	slots := make([]testPayload, 4)
+	slots[1].Name = "slot1"
+	codec := NewSlotCodec(NewJSONCodec[testPayload](), slots)
+	data, err := codec.Marshal(5, 1)
+	if err != nil {
+		t.Fatal("Marshal failed.", err)
+	}
+	codec.Unmarshal(6, 2, data)
+	if slots[2].Name != "slot1" {
+		t.Error("SlotCodec should read and write slots by index.")
+	}
+}
+
+func TestOfferValue(t *testing.T) {
+	slots := make([]testPayload, 4)
+	names := make([]string, 0, 8)
+	codec := NewGobCodec[testPayload]()
+	tm := NewTaskManager(4)
+	tm.AddHandler(func(id SequenceID, index int) {
+		names = append(names, slots[index].Name)
+	})
+	tm.SetOverflow(filepath.Join(t.TempDir(), "spill"), 4096, NewSlotCodec(codec, slots))
+	tm.Start()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err := OfferValue(tm, codec, &testPayload{Name: name}); err != nil {
+			t.Error("OfferValue failed.", err)
+		}
+	}
+	tm.Stop()
+	if len(names) != 8 || names[0] != "a" || names[7] != "h" {
+		t.Error("OfferValue should put values in order.", names)
+	}
+}
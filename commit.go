@@ -0,0 +1,49 @@
+package goseq
+
+import (
+	"context"
+	"io"
+)
+
+type syncer interface {
+	Sync() error
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Create a BatchHandler which writes payloads from 'from' to 'to' with one
+// Write() call and then syncs w once.
+func newCommitHandler(w io.Writer, codec SlotCodec, toIndexFunc sequenceIDToIndexFunc) BatchHandler {
+	var buf []byte
+	return func(ctx context.Context, from, to SequenceID) error {
+		buf = buf[:0]
+		for id := from; id <= to; id++ {
+			data, err := codec.Marshal(id, toIndexFunc(id))
+			if err != nil {
+				return err
+			}
+			buf = appendEntry(buf, id, data)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+		if s, ok := w.(syncer); ok {
+			return s.Sync()
+		}
+		return nil
+	}
+}
+
+// Read entries written by a committer from r and call handler for each
+// entry. Reading stops at the end of r or at a broken entry. data is reused
+// for the next entry, so copy it to keep it after handler returns.
+func ReadCommitLog(r io.Reader, handler func(id SequenceID, data []byte) error) error {
+	return readEntries(r, handler)
+}
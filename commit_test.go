@@ -0,0 +1,166 @@
+package goseq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSyncWriter struct {
+	lock      sync.Mutex
+	buf       bytes.Buffer
+	writes    int
+	syncs     int
+	committed SequenceID
+	pending   SequenceID
+}
+
+func (w *testSyncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writes++
+	ReadCommitLog(bytes.NewReader(p), func(id SequenceID, data []byte) error {
+		w.pending = id
+		return nil
+	})
+	return w.buf.Write(p)
+}
+
+func (w *testSyncWriter) Sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.syncs++
+	w.committed = w.pending
+	return nil
+}
+
+func (w *testSyncWriter) committedID() SequenceID {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.committed
+}
+
+func TestAddCommitter(t *testing.T) {
+	w := &testSyncWriter{committed: initialSequenceValue}
+	codec := &testSlotCodec{slots: make([]string, 8)}
+	uncommitted := 0
+	tm := NewTaskManager(8)
+	tm.AddCommitter(w, codec).Then(func(id SequenceID, index int) {
+		if w.committedID() < id {
+			uncommitted++
+		}
+	})
+	tm.Start()
+	for i := 0; i < 100; i++ {
+		tm.Put(func(id SequenceID, index int) {
+			codec.slots[index] = strconv.Itoa(int(id))
+		})
+	}
+	tm.Stop()
+
+	if uncommitted != 0 {
+		t.Error("Next groups should receive only committed ids. count:", uncommitted)
+	}
+	if w.writes != w.syncs || w.writes > 100 {
+		t.Error("A committer should write and sync once for each batch.", w.writes, w.syncs)
+	}
+	count := 0
+	ReadCommitLog(&w.buf, func(id SequenceID, data []byte) error {
+		if string(data) != strconv.Itoa(count) || id != SequenceID(count) {
+			t.Error("A committer should write payloads in order.", id, string(data))
+		}
+		count++
+		return nil
+	})
+	if count != 100 {
+		t.Error("A committer should write all payloads. count:", count)
+	}
+}
+
+type testFailingWriter struct {
+	lock     sync.Mutex
+	failures int
+	buf      bytes.Buffer
+}
+
+func (w *testFailingWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.failures != 0 {
+		w.failures--
+		return 0, errors.New("write error")
+	}
+	return w.buf.Write(p)
+}
+
+func TestCommitterRetries(t *testing.T) {
+	w := &testFailingWriter{failures: 3}
+	codec := &testSlotCodec{slots: make([]string, 8)}
+	errs := 0
+	received := make([]SequenceID, 0, 10)
+	tm := NewTaskManager(8)
+	group := tm.AddCommitter(w, codec)
+	group.SetErrorHandler(func(id SequenceID, index int, err error) {
+		errs++
+	})
+	group.Then(func(id SequenceID, index int) {
+		received = append(received, id)
+	})
+	tm.Start()
+	var id SequenceID
+	for i := 0; i < 10; i++ {
+		id = tm.Put(nil)
+	}
+	group.WaitFor(context.Background(), id)
+	tm.Stop()
+
+	if errs != 3 {
+		t.Error("Each failure should be passed to ErrorHandler.", errs)
+	}
+	if len(received) != 10 {
+		t.Error("Next groups should receive all ids after retrying.", received)
+	}
+	count := 0
+	ReadCommitLog(&w.buf, func(id SequenceID, data []byte) error {
+		if id != SequenceID(count) {
+			t.Error("A committer should write each id once in order.", id, count)
+		}
+		count++
+		return nil
+	})
+	if count != 10 {
+		t.Error("A committer should write all payloads. count:", count)
+	}
+}
+
+func TestCommitterGivesUpAfterStop(t *testing.T) {
+	w := &testFailingWriter{failures: -1}
+	codec := &testSlotCodec{slots: make([]string, 8)}
+	received := 0
+	tm := NewTaskManager(8)
+	group := tm.AddCommitter(w, codec)
+	next := group.Then(func(id SequenceID, index int) {
+		received++
+	})
+	tm.Start()
+	for i := 0; i < 5; i++ {
+		tm.Put(nil)
+	}
+	stopped := make(chan bool)
+	go func() {
+		tm.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop should return when a committer keeps failing.")
+	}
+	if received != 0 || group.LastProcessedID() != initialSequenceValue || next.LastProcessedID() != initialSequenceValue {
+		t.Error("Failed ids should not be finished.", received, group.LastProcessedID(), next.LastProcessedID())
+	}
+}
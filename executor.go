@@ -1,128 +1,702 @@
 package goseq
 
 import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
+// RejectionPolicy decides what Execute() does when the job queue is full.
+type RejectionPolicy int
 
 const (
-	closeExecutorIndex = -1
+	// BlockPolicy blocks a caller until the queue has space.
+	BlockPolicy RejectionPolicy = iota
+	// FailFastPolicy returns a Future which fails with ErrQueueFull.
+	FailFastPolicy
+	// CallerRunsPolicy runs a job in the caller's goroutine.
+	CallerRunsPolicy
+	// DiscardOldestPolicy drops the oldest queued job to make space
+	// regardless of its priority. Its Future fails with ErrJobDiscarded.
+	DiscardOldestPolicy
 )
 
+var (
+	// ErrQueueFull is returned by Future.Result() for a job rejected by
+	// FailFastPolicy.
+	ErrQueueFull = errors.New("goseq: executor queue is full")
+	// ErrJobDiscarded is returned by Future.Result() for a job dropped by
+	// DiscardOldestPolicy.
+	ErrJobDiscarded = errors.New("goseq: job was discarded")
+	// ErrExecutorShutdown is returned by Future.Result() for a job executed
+	// after Shutdown().
+	ErrExecutorShutdown = errors.New("goseq: executor is shut down")
+)
+
+// PanicError is returned by Future.Result() when a job panics. Stack is the
+// stack trace of the job's goroutine at the panic.
+type PanicError struct {
+	Value Any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("goseq: job panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// Return Value when it is an error, so errors.Is() and errors.As() can see it.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 type Any interface{}
 
 type Job func() (Any, error)
 
+// ContextJob is a Job which receives a context. The context is cancelled
+// when a caller cancels it, when its deadline passes or when the Executor
+// is stopped.
+type ContextJob func(ctx context.Context) (Any, error)
+
 type Executor interface {
 	Max() int
+	SetMax(max int)
+	SetCore(core int, keepAlive time.Duration)
 	Execute(runnable Job) Future
+	ExecuteContext(ctx context.Context, job ContextJob) Future
+	ExecutePriority(priority int, job Job) Future
+	SetAging(interval time.Duration)
+	Shutdown()
+	ShutdownNow() []Job
+	AwaitTermination(ctx context.Context) error
 	Stop()
 }
 
+// Jobs wait in queue until one of max workers takes them. notEmpty wakes
+// workers and notFull wakes callers blocked by BlockPolicy. Workers more
+// than core retire after waiting for keepAlive without jobs. queue is a heap
+// ordered by priority and then by submission.
 type executor struct {
-	lock                sync.Mutex
-	max                 int
-	freeJobIndexChannel chan int
-	jobIndexChannel     chan int
-	resultChannels      []chan Future
-	jobs                []Job
-	waitingStop         sync.WaitGroup
+	lock       sync.Mutex
+	notEmpty   *sync.Cond
+	notFull    *sync.Cond
+	ctx        context.Context
+	cancel     context.CancelFunc
+	max        int
+	core       int
+	keepAlive  time.Duration
+	idle       int
+	queue      taskQueue
+	queueSize  int
+	submitted  uint64
+	policy     RejectionPolicy
+	stopping   bool
+	workers    int
+	terminated chan struct{}
+}
+
+// index is the position in the heap to remove a task by
+// DiscardOldestPolicy or by cancellation of ctx. It is -1 while the task
+// isn't in queue. unwatch stops watching ctx of ExecuteContext().
+type task struct {
+	job      Job
+	future   *future
+	priority int
+	seq      uint64
+	queuedAt time.Time
+	index    int
+	unwatch  func() bool
+}
+
+// aging is an interval to raise priority of a waiting task by 1. It is 0
+// when aging is disabled.
+type taskQueue struct {
+	tasks []*task
+	aging time.Duration
 }
 
+// Future is a result of a job. Done() is closed when the job finishes, so
+// this can be used in a select statement.
 type Future interface {
 	Result() (Any, error)
+	ResultTimeout(timeout time.Duration) (Any, error)
+	ResultContext(ctx context.Context) (Any, error)
+	Done() <-chan struct{}
+	OnComplete(callback func(result Any, err error))
+}
+
+// TypedFuture is a Future which returns a result as T. This is created by
+// Submit() and SubmitContext().
+type TypedFuture[T any] interface {
+	Result() (T, error)
+	ResultTimeout(timeout time.Duration) (T, error)
+	ResultContext(ctx context.Context) (T, error)
+	Done() <-chan struct{}
+	OnComplete(callback func(result T, err error))
 }
 
+type typedFuture[T any] struct {
+	future Future
+}
+
+// result and err are written before closing done, so they can be read
+// without the lock after done is closed.
 type future struct {
-	finished    bool
-	waitChannel chan bool
-	result      Any
-	err         error
+	lock      sync.Mutex
+	ex        Executor
+	done      chan struct{}
+	result    Any
+	err       error
+	callbacks []func(result Any, err error)
 }
 
+// Create an Executor with max workers. Execute() queues up to max jobs and
+// then blocks until a worker takes a queued job. max less than 1 is treated
+// as 1.
 func NewExecutor(max int) Executor {
-	return newExecutor(max)
+	return newExecutor(max, max, BlockPolicy)
 }
 
-func newExecutor(max int) (ex *executor) {
+// Create an Executor with max workers and a queue for queueSize jobs.
+// policy is applied when the queue is full. queueSize less than 1 is
+// treated as 1.
+func NewExecutorWithQueue(max, queueSize int, policy RejectionPolicy) Executor {
+	return newExecutor(max, queueSize, policy)
+}
+
+func newExecutor(max, queueSize int, policy RejectionPolicy) (ex *executor) {
 	ex = new(executor)
+	if max < 1 {
+		max = 1
+	}
 	ex.max = max
-	ex.jobs = make([]Job, max)
+	ex.core = max
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	ex.queueSize = queueSize
+	ex.policy = policy
+	ex.queue.tasks = make([]*task, 0, queueSize)
+	ex.notEmpty = sync.NewCond(&ex.lock)
+	ex.notFull = sync.NewCond(&ex.lock)
+	ex.ctx, ex.cancel = context.WithCancel(context.Background())
+	ex.terminated = make(chan struct{})
+	ex.lock.Lock()
 	ex.startWorkers()
+	ex.lock.Unlock()
 	return ex
 }
 
+// Start workers up to core, and more workers up to max while queued jobs
+// are more than idle workers. This must be called with the lock.
 func (ex *executor) startWorkers() {
-	ex.jobIndexChannel = make(chan int)
-	ex.resultChannels = make([]chan Future, ex.max)
-	ex.freeJobIndexChannel = make(chan int, ex.max)
-	ex.waitingStop.Add(ex.max)
-	for i := 0; i < ex.max; i++ {
-		ex.resultChannels[i] = make(chan Future, 1)
+	for ex.workers < ex.max && (ex.workers < ex.core || ex.queue.Len() > ex.idle) {
+		ex.workers++
 		go ex.startWorker()
-		ex.freeJobIndexChannel <- i
 	}
 }
 
+// Cancel contexts of jobs, run queued jobs and then wait for all workers.
 func (ex *executor) Stop() {
-	for i := 0;i < ex.max;i++ {
-		ex.jobIndexChannel <- closeExecutorIndex
+	ex.cancel()
+	ex.Shutdown()
+	ex.AwaitTermination(context.Background())
+}
+
+// Reject new jobs with ErrExecutorShutdown. Queued and running jobs are
+// finished. This doesn't wait for them, so call AwaitTermination().
+func (ex *executor) Shutdown() {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	ex.shutdown()
+}
+
+// Reject new jobs, cancel contexts of running jobs and return queued jobs
+// which are not started. Futures of the returned jobs fail with
+// context.Canceled.
+func (ex *executor) ShutdownNow() []Job {
+	ex.cancel()
+	ex.lock.Lock()
+	ex.shutdown()
+	pending := make([]*task, 0, ex.queue.Len())
+	for ex.queue.Len() > 0 {
+		pending = append(pending, ex.dequeue())
+	}
+	ex.lock.Unlock()
+	jobs := make([]Job, len(pending))
+	for i, t := range pending {
+		jobs[i] = t.job
+		t.future.complete(nil, context.Canceled)
 	}
-	close(ex.jobIndexChannel)
-	close(ex.freeJobIndexChannel)
-	for i := range ex.jobs {
-		ex.jobs[i] = nil
+	return jobs
+}
+
+// Block until all workers finish after Shutdown(). ctx.Err() is returned
+// when ctx is done first.
+func (ex *executor) AwaitTermination(ctx context.Context) error {
+	select {
+	case <-ex.terminated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// This must be called with the lock.
+func (ex *executor) shutdown() {
+	if ex.stopping {
+		return
 	}
-	for _,ch := range ex.resultChannels {
-		close(ch)
+	ex.stopping = true
+	ex.notEmpty.Broadcast()
+	ex.notFull.Broadcast()
+	if ex.workers == 0 {
+		close(ex.terminated)
 	}
-	ex.waitingStop.Wait()
 }
 
 func newFuture() (f *future) {
 	f = new(future)
-	f.finished = false
-	f.waitChannel = make(chan bool, 1)
+	f.done = make(chan struct{})
 	return f
 }
 
+// Create a future which is already finished with result and err.
+func newCompletedFuture(result Any, err error) (f *future) {
+	f = newFuture()
+	f.complete(result, err)
+	return f
+}
+
+// Set the result, wake waiting goroutines and then call callbacks
+// registered by OnComplete(). Only the first call sets the result.
+func (f *future) complete(result Any, err error) {
+	f.lock.Lock()
+	select {
+	case <-f.done:
+		f.lock.Unlock()
+		return
+	default:
+	}
+	f.result, f.err = result, err
+	close(f.done)
+	callbacks := f.callbacks
+	f.callbacks = nil
+	f.lock.Unlock()
+	for _, callback := range callbacks {
+		callback(result, err)
+	}
+}
+
 func (ex *executor) startWorker() {
-	defer ex.waitingStop.Done()
 	for {
-		jobIndex := <- ex.jobIndexChannel
-		if jobIndex < 0 {
+		t := ex.take()
+		if t == nil {
 			break
 		}
-		f := newFuture()
-		ex.resultChannels[jobIndex] <- f
-		f.result, f.err = ex.jobs[jobIndex]()
-		f.waitChannel <- true
-		close(f.waitChannel)
-		ex.addFreeJobIndexChannel(jobIndex)
+		t.run()
+	}
+}
+
+func (t *task) run() {
+	if t.unwatch != nil {
+		t.unwatch()
 	}
+	t.future.complete(t.call())
 }
 
-// I'm not sure writing to channel needs to lock or not.
-func (ex *executor) addFreeJobIndexChannel(jobIndex int) {
+// Call the job and convert a panic to PanicError, so a worker survives it.
+func (t *task) call() (result Any, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			result, err = nil, &PanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return t.job()
+}
+
+// Block until a job is queued. This returns nil when the worker must exit
+// because the executor is stopping with an empty queue, the pool shrinks or
+// the worker is idle longer than keepAlive.
+func (ex *executor) take() *task {
 	ex.lock.Lock()
 	defer ex.lock.Unlock()
-	ex.freeJobIndexChannel <- jobIndex
+	var idleSince time.Time
+	for {
+		if ex.workers > ex.max {
+			return ex.retire()
+		}
+		if ex.queue.Len() > 0 {
+			t := ex.dequeue()
+			ex.notFull.Signal()
+			return t
+		}
+		if ex.stopping {
+			return ex.retire()
+		}
+		if ex.workers <= ex.core || ex.keepAlive <= 0 {
+			ex.waitJob()
+			continue
+		}
+		if idleSince.IsZero() {
+			idleSince = time.Now()
+		}
+		remaining := ex.keepAlive - time.Since(idleSince)
+		if remaining <= 0 {
+			return ex.retire()
+		}
+		timer := time.AfterFunc(remaining, ex.wakeWorkers)
+		ex.waitJob()
+		timer.Stop()
+	}
+}
+
+// This must be called with the lock.
+func (ex *executor) waitJob() {
+	ex.idle++
+	ex.notEmpty.Wait()
+	ex.idle--
+}
+
+func (ex *executor) wakeWorkers() {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	ex.notEmpty.Broadcast()
+}
+
+// This must be called with the lock.
+func (ex *executor) retire() *task {
+	ex.workers--
+	if ex.workers == 0 && ex.stopping {
+		close(ex.terminated)
+	}
+	return nil
+}
+
+// Remove a task with the highest priority. This must be called with the
+// lock.
+func (ex *executor) dequeue() *task {
+	return heap.Pop(&ex.queue).(*task)
+}
+
+// Remove the task submitted first. This must be called with the lock.
+func (ex *executor) dequeueOldest() *task {
+	oldest := ex.queue.tasks[0]
+	for _, t := range ex.queue.tasks {
+		if t.seq < oldest.seq {
+			oldest = t
+		}
+	}
+	return heap.Remove(&ex.queue, oldest.index).(*task)
 }
 
 func (ex *executor) Max() int {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
 	return ex.max
 }
 
+// Change the maximum number of workers. When max becomes smaller, extra
+// workers retire after finishing their current jobs. core follows max when
+// they are the same or core becomes larger than max. max less than 1 is
+// treated as 1.
+func (ex *executor) SetMax(max int) {
+	if max < 1 {
+		max = 1
+	}
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if ex.core == ex.max || ex.core > max {
+		ex.core = max
+	}
+	ex.max = max
+	if !ex.stopping {
+		ex.startWorkers()
+	}
+	ex.notEmpty.Broadcast()
+}
+
+// Keep core workers and let other workers up to max retire after waiting
+// for keepAlive without jobs. Workers more than core are started when
+// queued jobs are more than idle workers. keepAlive 0 keeps all workers.
+func (ex *executor) SetCore(core int, keepAlive time.Duration) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if core > ex.max {
+		core = ex.max
+	} else if core < 0 {
+		core = 0
+	}
+	ex.core = core
+	ex.keepAlive = keepAlive
+	ex.notEmpty.Broadcast()
+}
+
 func (ex *executor) Execute(job Job) Future {
-	index := <-ex.freeJobIndexChannel
-	ex.jobs[index] = job
-	ex.jobIndexChannel <- index
-	return <-ex.resultChannels[index]
+	return ex.submit(context.Background(), 0, job)
 }
 
-func (f *future) Result() (Any, error) {
-	if !f.finished {
-		f.finished = <-f.waitChannel
+// Run job before queued jobs with lower priority. Execute() and
+// ExecuteContext() use priority 0. Jobs with the same priority run in
+// submission order.
+func (ex *executor) ExecutePriority(priority int, job Job) Future {
+	return ex.submit(context.Background(), priority, job)
+}
+
+// Raise priority of a queued job by 1 for every interval it waits, so jobs
+// with low priority don't starve. interval 0 disables aging.
+func (ex *executor) SetAging(interval time.Duration) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	ex.queue.aging = interval
+	heap.Init(&ex.queue)
+}
+
+// Run job with a context which is cancelled by ctx or Stop(). When ctx is
+// done before job starts, job is removed from queue and the returned Future
+// fails with ctx.Err() at once.
+func (ex *executor) ExecuteContext(ctx context.Context, job ContextJob) Future {
+	if err := ctx.Err(); err != nil {
+		return newCompletedFuture(nil, err)
+	}
+	return ex.submit(ctx, 0, func() (Any, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := ex.ctx.Err(); err != nil {
+			return nil, err
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(ex.ctx, cancel)()
+		return job(jobCtx)
+	})
+}
+
+// Queue job and apply the RejectionPolicy when the queue is full. ctx stops
+// waiting for space with BlockPolicy.
+func (ex *executor) submit(ctx context.Context, priority int, job Job) Future {
+	t := &task{job: job, future: newFuture(), priority: priority}
+	t.future.ex = ex
+	if ctx.Done() != nil && ex.policy == BlockPolicy {
+		defer context.AfterFunc(ctx, func() {
+			ex.lock.Lock()
+			defer ex.lock.Unlock()
+			ex.notFull.Broadcast()
+		})()
+	}
+	ex.lock.Lock()
+	for !ex.stopping && ex.queue.Len() >= ex.queueSize {
+		switch ex.policy {
+		case FailFastPolicy:
+			ex.lock.Unlock()
+			return newCompletedFuture(nil, ErrQueueFull)
+		case CallerRunsPolicy:
+			ex.lock.Unlock()
+			t.run()
+			return t.future
+		case DiscardOldestPolicy:
+			ex.dequeueOldest().future.complete(nil, ErrJobDiscarded)
+		default:
+			if err := ctx.Err(); err != nil {
+				// Pass a wakeup which this caller may have consumed.
+				ex.notFull.Signal()
+				ex.lock.Unlock()
+				return newCompletedFuture(nil, err)
+			}
+			ex.notFull.Wait()
+		}
+	}
+	if ex.stopping {
+		ex.lock.Unlock()
+		return newCompletedFuture(nil, ErrExecutorShutdown)
+	}
+	ex.submitted++
+	t.seq = ex.submitted
+	// This is stamped without aging too, because SetAging() can enable aging
+	// while the task waits.
+	t.queuedAt = time.Now()
+	heap.Push(&ex.queue, t)
+	if ctx.Done() != nil {
+		t.unwatch = context.AfterFunc(ctx, func() {
+			ex.cancelQueued(t, ctx.Err())
+		})
+	}
+	ex.startWorkers()
+	ex.notEmpty.Signal()
+	ex.lock.Unlock()
+	return t.future
+}
+
+// Remove t from queue and fail its Future with err when t isn't started.
+func (ex *executor) cancelQueued(t *task, err error) {
+	ex.lock.Lock()
+	if t.index < 0 {
+		ex.lock.Unlock()
+		return
 	}
+	heap.Remove(&ex.queue, t.index)
+	ex.notFull.Signal()
+	ex.lock.Unlock()
+	t.future.complete(nil, err)
+}
+
+func (f *future) Result() (Any, error) {
+	<-f.done
 	return f.result, f.err
 }
+
+// Wait for the result up to timeout. context.DeadlineExceeded is returned
+// when the job doesn't finish in time.
+func (f *future) ResultTimeout(timeout time.Duration) (Any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return f.ResultContext(ctx)
+}
+
+// Wait for the result until ctx is done. ctx.Err() is returned when ctx is
+// done first. This doesn't cancel the job.
+func (f *future) ResultContext(ctx context.Context) (Any, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Return the Executor which runs the job. This is nil for a future which is
+// finished without an Executor.
+func (f *future) executor() Executor {
+	return f.ex
+}
+
+// Call callback with the result when the job finishes. callback is called
+// from the goroutine which finished the job, or immediately from the caller
+// when the job has already finished.
+func (f *future) OnComplete(callback func(result Any, err error)) {
+	f.lock.Lock()
+	select {
+	case <-f.done:
+		f.lock.Unlock()
+		callback(f.result, f.err)
+	default:
+		f.callbacks = append(f.callbacks, callback)
+		f.lock.Unlock()
+	}
+}
+
+// Run job on ex and return a Future typed with the job's result.
+func Submit[T any](ex Executor, job func() (T, error)) TypedFuture[T] {
+	return &typedFuture[T]{future: ex.Execute(func() (Any, error) {
+		return job()
+	})}
+}
+
+// The same as Submit() with ctx like Executor.ExecuteContext().
+func SubmitContext[T any](ex Executor, ctx context.Context, job func(ctx context.Context) (T, error)) TypedFuture[T] {
+	return &typedFuture[T]{future: ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		return job(ctx)
+	})}
+}
+
+// Return the zero value of T with an error when the job didn't finish.
+func (f *typedFuture[T]) Result() (T, error) {
+	return typed[T](f.future.Result())
+}
+
+func (f *typedFuture[T]) ResultTimeout(timeout time.Duration) (T, error) {
+	return typed[T](f.future.ResultTimeout(timeout))
+}
+
+func (f *typedFuture[T]) ResultContext(ctx context.Context) (T, error) {
+	return typed[T](f.future.ResultContext(ctx))
+}
+
+func (f *typedFuture[T]) Done() <-chan struct{} {
+	return f.future.Done()
+}
+
+func (f *typedFuture[T]) executor() Executor {
+	return executorOf(f.future)
+}
+
+func (f *typedFuture[T]) OnComplete(callback func(result T, err error)) {
+	f.future.OnComplete(func(result Any, err error) {
+		callback(typed[T](result, err))
+	})
+}
+
+func typed[T any](result Any, err error) (T, error) {
+	value, _ := result.(T)
+	return value, err
+}
+
+// With aging, a task's effective priority at time now is
+// priority + (now - queuedAt) / aging. The difference between two tasks
+// doesn't depend on now, so the heap stays valid while tasks wait.
+// Priorities are compared after adding whole intervals which a waited
+// longer than b, so extreme priorities don't overflow.
+func (q *taskQueue) Less(i, j int) bool {
+	a, b := q.tasks[i], q.tasks[j]
+	if q.aging <= 0 {
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.seq < b.seq
+	}
+	wait := b.queuedAt.Sub(a.queuedAt)
+	aged := saturatingAdd(int64(a.priority), int64(wait/q.aging))
+	switch {
+	case aged != int64(b.priority):
+		return aged > int64(b.priority)
+	case wait%q.aging != 0:
+		return wait%q.aging > 0
+	}
+	return a.seq < b.seq
+}
+
+// Return x + y limited to the range of int64.
+func saturatingAdd(x, y int64) int64 {
+	if y > 0 && x > math.MaxInt64-y {
+		return math.MaxInt64
+	}
+	if y < 0 && x < math.MinInt64-y {
+		return math.MinInt64
+	}
+	return x + y
+}
+
+func (q *taskQueue) Len() int {
+	return len(q.tasks)
+}
+
+func (q *taskQueue) Swap(i, j int) {
+	q.tasks[i], q.tasks[j] = q.tasks[j], q.tasks[i]
+	q.tasks[i].index = i
+	q.tasks[j].index = j
+}
+
+func (q *taskQueue) Push(x any) {
+	t := x.(*task)
+	t.index = len(q.tasks)
+	q.tasks = append(q.tasks, t)
+}
+
+func (q *taskQueue) Pop() any {
+	t := q.tasks[len(q.tasks)-1]
+	q.tasks[len(q.tasks)-1] = nil
+	t.index = -1
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return t
+}
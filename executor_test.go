@@ -1,37 +1,32 @@
 package goseq
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestNewExecutor(t *testing.T) {
-	ex := newExecutor(4)
+	ex := newExecutor(4, 4, BlockPolicy)
 	defer ex.Stop()
 	if ex.max != 4 {
 		t.Error("NewExecutor should initialize max value.")
 	}
-	if len(ex.jobs) != 4 {
-		t.Error("NewExecutor should initialize jobs table.")
+	if ex.queueSize != 4 || cap(ex.queue.tasks) != 4 {
+		t.Error("NewExecutor should initialize a job queue.")
 	}
-	if len(ex.resultChannels) != 4 {
-		t.Error("NewExecutor should initialize resultChannels table.")
-	}
-	if ex.jobIndexChannel == nil {
-		t.Error("NewExecutor should initialize jobIndexChannel.")
-	}
-	if ex.freeJobIndexChannel == nil {
-		t.Error("NewExecutor should initialize freeJobIndexChannel.")
-	}
-	if len(ex.freeJobIndexChannel) != 4 {
-		t.Error("NewExecutor should have free list.")
+	if ex.notEmpty == nil || ex.notFull == nil {
+		t.Error("NewExecutor should initialize conditions.")
 	}
 }
 
 func TestMax(t *testing.T) {
-	ex := newExecutor(4)
+	ex := newExecutor(4, 4, BlockPolicy)
 	defer ex.Stop()
 	if ex.Max() != 4 {
 		t.Error("Executor.Max should return a max value.")
@@ -39,7 +34,7 @@ func TestMax(t *testing.T) {
 }
 
 func TestExecute(t *testing.T) {
-	ex := newExecutor(4)
+	ex := newExecutor(4, 4, BlockPolicy)
 	defer ex.Stop()
 	f := ex.Execute(func() (Any, error) {
 		time.Sleep(10)
@@ -65,7 +60,7 @@ func createJobFunc(i int, errText string) Job {
 }
 
 func TestRunFuture(t *testing.T) {
-	ex := newExecutor(2)
+	ex := newExecutor(2, 2, BlockPolicy)
 	defer ex.Stop()
 
 	f1 := ex.Execute(createJobFunc(1, ""))
@@ -80,7 +75,7 @@ func TestRunFuture(t *testing.T) {
 }
 
 func TestAddSomeJobs(t *testing.T) {
-	ex := newExecutor(2)
+	ex := newExecutor(2, 2, BlockPolicy)
 	defer ex.Stop()
 	results := make([]Future, 5)
 	for i := 0; i < 5; i++ {
@@ -100,10 +95,444 @@ func TestAddSomeJobs(t *testing.T) {
 
 func TestNewFuture(t *testing.T) {
 	f := newFuture()
-	if f.finished {
-		t.Error("future.finished should be false.")
+	if f.done == nil {
+		t.Error("future.done should be initialized.")
+	}
+	select {
+	case <-f.Done():
+		t.Error("A new future should not be finished.")
+	default:
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	ex := newExecutor(2, 2, BlockPolicy)
+	defer ex.Stop()
+	ctx := context.WithValue(context.Background(), testContextKey{}, "value")
+	f := ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		return ctx.Value(testContextKey{}), nil
+	})
+	if res, err := f.Result(); res != "value" || err != nil {
+		t.Error("ExecuteContext should pass ctx to a job.", res, err)
+	}
+}
+
+func TestExecuteContextCanceled(t *testing.T) {
+	ex := newExecutor(1, 1, BlockPolicy)
+	defer ex.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	f := ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		called = true
+		return nil, nil
+	})
+	if _, err := f.Result(); err != context.Canceled || called {
+		t.Error("A job cancelled before starting should not run.", err)
+	}
+}
+
+func TestExecuteContextCanceledInQueue(t *testing.T) {
+	ex := newExecutor(1, 1, BlockPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		return nil, nil
+	})
+	cancel()
+	if _, err := f.ResultTimeout(time.Second); err != context.Canceled {
+		t.Error("A queued job should finish as soon as ctx is cancelled.", err)
+	}
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if ex.queue.Len() != 0 {
+		t.Error("A cancelled job should be removed from queue.", ex.queue.Len())
+	}
+}
+
+func TestExecuteContextDeadline(t *testing.T) {
+	ex := newExecutor(1, 1, BlockPolicy)
+	defer ex.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	f := ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if _, err := f.Result(); err != context.DeadlineExceeded {
+		t.Error("A job should be cancelled by a deadline.", err)
+	}
+}
+
+func TestExecuteContextStop(t *testing.T) {
+	ex := newExecutor(1, 1, BlockPolicy)
+	started := make(chan bool)
+	f := ex.ExecuteContext(context.Background(), func(ctx context.Context) (Any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	ex.Stop()
+	if _, err := f.Result(); err != context.Canceled {
+		t.Error("Stop should cancel running jobs.", err)
+	}
+}
+
+// Occupy a worker of ex until the returned channel is closed.
+func blockWorker(ex Executor) chan bool {
+	started := make(chan bool)
+	release := make(chan bool)
+	ex.Execute(func() (Any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	return release
+}
+
+func TestFailFastPolicy(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 1, FailFastPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	queued := ex.Execute(createJobFunc(1, ""))
+	rejected := ex.Execute(createJobFunc(2, ""))
+	if _, err := rejected.Result(); err != ErrQueueFull {
+		t.Error("FailFastPolicy should reject a job.", err)
+	}
+	close(release)
+	if res, _ := queued.Result(); res != "finished1" {
+		t.Error("A queued job should run.", res)
+	}
+}
+
+func TestCallerRunsPolicy(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 1, CallerRunsPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	defer close(release)
+	ex.Execute(createJobFunc(1, ""))
+	done := false
+	f := ex.Execute(func() (Any, error) {
+		done = true
+		return nil, nil
+	})
+	if !done {
+		t.Error("CallerRunsPolicy should run a job in the caller.")
+	}
+	f.Result()
+}
+
+func TestDiscardOldestPolicy(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 1, DiscardOldestPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	oldest := ex.Execute(createJobFunc(1, ""))
+	newest := ex.Execute(createJobFunc(2, ""))
+	if _, err := oldest.Result(); err != ErrJobDiscarded {
+		t.Error("DiscardOldestPolicy should discard the oldest job.", err)
+	}
+	close(release)
+	if res, _ := newest.Result(); res != "finished2" {
+		t.Error("A new job should be queued.", res)
+	}
+}
+
+func TestBlockPolicy(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 1, BlockPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	ex.Execute(createJobFunc(1, ""))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	f := ex.ExecuteContext(ctx, func(ctx context.Context) (Any, error) {
+		return nil, nil
+	})
+	if _, err := f.Result(); err != context.DeadlineExceeded {
+		t.Error("BlockPolicy should wait for space until ctx is done.", err)
+	}
+	close(release)
+	if res, _ := ex.Execute(createJobFunc(3, "")).Result(); res != "finished3" {
+		t.Error("BlockPolicy should queue a job after space is available.", res)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	ex := NewExecutor(2)
+	defer ex.Stop()
+	f := Submit(ex, func() (int, error) {
+		return 42, nil
+	})
+	if res, err := f.Result(); res != 42 || err != nil {
+		t.Error("Submit should return a typed result.", res, err)
+	}
+}
+
+func TestSubmitContextCanceled(t *testing.T) {
+	ex := NewExecutor(1)
+	defer ex.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := SubmitContext(ex, ctx, func(ctx context.Context) (string, error) {
+		return "finished", nil
+	})
+	if res, err := f.Result(); res != "" || err != context.Canceled {
+		t.Error("A typed Future should return the zero value for a cancelled job.", res, err)
+	}
+}
+
+func TestFutureResultTimeout(t *testing.T) {
+	f := newFuture()
+	if _, err := f.ResultTimeout(time.Millisecond); err != context.DeadlineExceeded {
+		t.Error("ResultTimeout should return an error when the job doesn't finish.", err)
+	}
+	f.complete("finished", nil)
+	if res, err := f.ResultTimeout(time.Millisecond); res != "finished" || err != nil {
+		t.Error("ResultTimeout should return the result.", res, err)
+	}
+}
+
+func TestFutureResultContext(t *testing.T) {
+	f := newFuture()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.ResultContext(ctx); err != context.Canceled {
+		t.Error("ResultContext should return ctx.Err().", err)
 	}
-	if f.waitChannel == nil {
-		t.Error("future.waitChannel should be initialized.")
+}
+
+func TestFutureOnComplete(t *testing.T) {
+	f := newFuture()
+	results := make(chan Any, 2)
+	f.OnComplete(func(result Any, err error) {
+		results <- result
+	})
+	f.complete("finished", nil)
+	f.OnComplete(func(result Any, err error) {
+		results <- result
+	})
+	if <-results != "finished" || <-results != "finished" {
+		t.Error("OnComplete should call callbacks before and after completion.")
+	}
+}
+
+func TestTypedFutureOnComplete(t *testing.T) {
+	ex := NewExecutor(1)
+	defer ex.Stop()
+	f := Submit(ex, func() (int, error) {
+		return 7, nil
+	})
+	results := make(chan int, 1)
+	f.OnComplete(func(result int, err error) {
+		results <- result
+	})
+	<-f.Done()
+	if <-results != 7 {
+		t.Error("OnComplete of TypedFuture should pass a typed result.")
+	}
+}
+
+func TestExecutePanic(t *testing.T) {
+	ex := NewExecutor(1)
+	defer ex.Stop()
+	f := ex.Execute(func() (Any, error) {
+		panic("broken")
+	})
+	_, err := f.Result()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "broken" || !strings.Contains(string(panicErr.Stack), "TestExecutePanic") {
+		t.Error("A panic should be returned as PanicError with a stack.", err)
+	}
+	if res, _ := ex.Execute(createJobFunc(1, "")).Result(); res != "finished1" {
+		t.Error("A worker should keep running after a panic.", res)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ex := NewExecutor(1)
+	release := blockWorker(ex)
+	queued := ex.Execute(createJobFunc(1, ""))
+	ex.Shutdown()
+	if _, err := ex.Execute(createJobFunc(2, "")).Result(); err != ErrExecutorShutdown {
+		t.Error("Execute after Shutdown should fail.", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := ex.AwaitTermination(ctx); err != context.DeadlineExceeded {
+		t.Error("AwaitTermination should wait for running jobs.", err)
+	}
+	close(release)
+	if err := ex.AwaitTermination(context.Background()); err != nil {
+		t.Error("AwaitTermination should return after all jobs finish.", err)
+	}
+	if res, _ := queued.Result(); res != "finished1" {
+		t.Error("Shutdown should finish queued jobs.", res)
+	}
+}
+
+func TestShutdownNow(t *testing.T) {
+	ex := NewExecutor(1)
+	started := make(chan bool)
+	running := ex.ExecuteContext(context.Background(), func(ctx context.Context) (Any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	queued := ex.Execute(createJobFunc(1, ""))
+	jobs := ex.ShutdownNow()
+	if len(jobs) != 1 {
+		t.Error("ShutdownNow should return queued jobs.", len(jobs))
+	}
+	if _, err := queued.Result(); err != context.Canceled {
+		t.Error("Futures of queued jobs should be cancelled.", err)
+	}
+	if _, err := running.Result(); err != context.Canceled {
+		t.Error("ShutdownNow should cancel running jobs.", err)
+	}
+	if err := ex.AwaitTermination(context.Background()); err != nil {
+		t.Error("AwaitTermination should return after ShutdownNow.", err)
+	}
+}
+
+func (ex *executor) numOfWorkers() int {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	return ex.workers
+}
+
+func waitWorkers(ex *executor, n int) bool {
+	for i := 0; i < 1000; i++ {
+		if ex.numOfWorkers() == n {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestSetMax(t *testing.T) {
+	ex := newExecutor(1, 4, BlockPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	ex.SetMax(2)
+	if ex.Max() != 2 || ex.core != 2 {
+		t.Error("SetMax should change max and core.", ex.Max(), ex.core)
+	}
+	if res, _ := ex.Execute(createJobFunc(1, "")).Result(); res != "finished1" {
+		t.Error("A new worker should run a job while another worker is busy.", res)
+	}
+	ex.SetMax(1)
+	close(release)
+	if !waitWorkers(ex, 1) {
+		t.Error("SetMax should retire extra workers.", ex.numOfWorkers())
+	}
+}
+
+func TestSetCore(t *testing.T) {
+	ex := newExecutor(2, 4, BlockPolicy)
+	defer ex.Stop()
+	ex.SetCore(1, 20*time.Millisecond)
+	if !waitWorkers(ex, 1) {
+		t.Error("Idle workers more than core should retire.", ex.numOfWorkers())
+	}
+	release := blockWorker(ex)
+	f := ex.Execute(createJobFunc(1, ""))
+	if ex.numOfWorkers() != 2 {
+		t.Error("A worker should start up to max for a queued job.", ex.numOfWorkers())
+	}
+	if res, _ := f.Result(); res != "finished1" {
+		t.Error("A new worker should run a queued job.", res)
+	}
+	close(release)
+	if !waitWorkers(ex, 1) {
+		t.Error("A worker should retire after keepAlive.", ex.numOfWorkers())
+	}
+}
+
+// Queue jobs which record their names while a worker is blocked, and then
+// return the names in the order the jobs ran.
+func runQueued(ex Executor, submit func(run func(name string) Job)) []string {
+	var lock sync.Mutex
+	names := make([]string, 0)
+	release := blockWorker(ex)
+	submit(func(name string) Job {
+		return func() (Any, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			names = append(names, name)
+			return nil, nil
+		}
+	})
+	close(release)
+	ex.Stop()
+	return names
+}
+
+func TestExecutePriority(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 10, BlockPolicy)
+	names := runQueued(ex, func(run func(name string) Job) {
+		ex.ExecutePriority(0, run("low"))
+		ex.ExecutePriority(5, run("high"))
+		ex.ExecutePriority(1, run("middle1"))
+		ex.ExecutePriority(1, run("middle2"))
+	})
+	if strings.Join(names, ",") != "high,middle1,middle2,low" {
+		t.Error("Jobs should run in order of priority.", names)
+	}
+}
+
+func TestSetAging(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 10, BlockPolicy)
+	ex.SetAging(time.Millisecond)
+	names := runQueued(ex, func(run func(name string) Job) {
+		ex.ExecutePriority(0, run("old"))
+		time.Sleep(5 * time.Millisecond)
+		ex.ExecutePriority(2, run("new"))
+	})
+	if strings.Join(names, ",") != "old,new" {
+		t.Error("A job waiting long should run before new jobs with higher priority.", names)
+	}
+}
+
+func TestSetAgingWhileQueued(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 10, BlockPolicy)
+	names := runQueued(ex, func(run func(name string) Job) {
+		ex.ExecutePriority(0, run("low"))
+		ex.ExecutePriority(1, run("middle"))
+		ex.SetAging(time.Hour)
+		ex.ExecutePriority(2, run("high"))
+	})
+	if strings.Join(names, ",") != "high,middle,low" {
+		t.Error("Jobs queued before SetAging() should keep their order by priority.", names)
+	}
+}
+
+func TestSetAgingWithExtremePriorities(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 10, BlockPolicy)
+	ex.SetAging(time.Nanosecond)
+	names := runQueued(ex, func(run func(name string) Job) {
+		ex.ExecutePriority(math.MinInt, run("min"))
+		ex.ExecutePriority(math.MaxInt, run("max"))
+		ex.ExecutePriority(0, run("zero"))
+	})
+	if strings.Join(names, ",") != "max,zero,min" {
+		t.Error("Aging should not overflow with extreme priorities.", names)
+	}
+}
+
+func TestDiscardOldestPolicyWithPriority(t *testing.T) {
+	ex := NewExecutorWithQueue(1, 2, DiscardOldestPolicy)
+	defer ex.Stop()
+	release := blockWorker(ex)
+	oldest := ex.ExecutePriority(5, createJobFunc(1, ""))
+	ex.ExecutePriority(0, createJobFunc(2, ""))
+	ex.ExecutePriority(0, createJobFunc(3, ""))
+	if _, err := oldest.Result(); err != ErrJobDiscarded {
+		t.Error("DiscardOldestPolicy should discard the oldest job regardless of priority.", err)
 	}
+	close(release)
 }
@@ -0,0 +1,89 @@
+//go:build linux
+
+package goseq
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	fileSequenceMagic   = 0x474f5345515351 // "GOSEQSQ"
+	fileSequenceVersion = 1
+	fileSequenceSize    = 2 * cacheLineSize
+)
+
+// ErrSequenceFile is returned when a file is not created by OpenFileSequence.
+var ErrSequenceFile = errors.New("goseq: not a sequence file")
+
+// FileSequence is a Sequence whose value is kept in a memory mapped file.
+// The value survives restarts and other processes opening the same file
+// see the same value. Sync() flushes the value to a disk.
+type FileSequence interface {
+	Sequence
+	Sync() error
+	Close() error
+}
+
+type fileSequence struct {
+	*mmapSequence
+	file *os.File
+	data []byte
+}
+
+// Open a FileSequence at path. A new file starts from the same value
+// as NewSequence().
+func OpenFileSequence(path string) (FileSequence, error) {
+	file, err := openOrCreateFile(path, initFileSequence)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != fileSequenceSize {
+		file.Close()
+		if err == nil {
+			err = ErrSequenceFile
+		}
+		return nil, err
+	}
+	data, err := mapFile(file, fileSequenceSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	seq := &fileSequence{file: file, data: data}
+	if atomic.LoadInt64(int64At(data, 0)) != fileSequenceMagic ||
+		atomic.LoadInt64(int64At(data, 8)) != fileSequenceVersion {
+		seq.Close()
+		return nil, ErrSequenceFile
+	}
+	seq.mmapSequence = newMmapSequence(int64At(data, cacheLineSize))
+	return seq, nil
+}
+
+func initFileSequence(file *os.File) error {
+	if err := file.Truncate(fileSequenceSize); err != nil {
+		return err
+	}
+	data, err := mapFile(file, fileSequenceSize)
+	if err != nil {
+		return err
+	}
+	defer unmapFile(data)
+	*int64At(data, 0) = fileSequenceMagic
+	*int64At(data, 8) = fileSequenceVersion
+	*int64At(data, cacheLineSize) = initialSequenceValue
+	return syncMapping(data)
+}
+
+func (seq *fileSequence) Sync() error {
+	return syncMapping(seq.data)
+}
+
+func (seq *fileSequence) Close() error {
+	err := unmapFile(seq.data)
+	if closeErr := seq.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package goseq
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenFileSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seq")
+	seq, err := OpenFileSequence(path)
+	if err != nil {
+		t.Fatal("OpenFileSequence failed.", err)
+	}
+	if seq.Get() != initialSequenceValue {
+		t.Error("A new FileSequence should have the initial value.")
+	}
+	if seq.Next() != 0 {
+		t.Error("Next should return 0 for the first id.")
+	}
+	seq.Set(42)
+	if err := seq.Sync(); err != nil {
+		t.Error("Sync failed.", err)
+	}
+	seq.Close()
+
+	seq, err = OpenFileSequence(path)
+	if err != nil {
+		t.Fatal("OpenFileSequence failed to reopen.", err)
+	}
+	defer seq.Close()
+	if seq.Get() != 42 {
+		t.Error("FileSequence should keep a value after reopening. value:", seq.Get())
+	}
+}
+
+func TestFileSequenceShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seq")
+	writer, _ := OpenFileSequence(path)
+	defer writer.Close()
+	reader, _ := OpenFileSequence(path)
+	defer reader.Close()
+	go func() {
+		time.Sleep(time.Millisecond)
+		writer.Set(7)
+	}()
+	id, err := reader.WaitFor(context.Background(), 7)
+	if err != nil || id != 7 {
+		t.Error("WaitFor should see a value written through another mapping.", id, err)
+	}
+}
+
+func TestOpenFileSequenceWrongFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seq")
+	os.WriteFile(path, []byte("not a sequence"), 0644)
+	if _, err := OpenFileSequence(path); err != ErrSequenceFile {
+		t.Error("OpenFileSequence should reject a wrong file. err:", err)
+	}
+}
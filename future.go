@@ -0,0 +1,104 @@
+package goseq
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNoFuture is returned by AnyOf() without futures.
+var ErrNoFuture = errors.New("goseq: no future is given")
+
+// Combinators accept an untyped Future as TypedFuture[Any], for example
+// AllOf[Any](f1, f2).
+
+// Return a Future of all results in the order of futures. This fails with
+// the first error without waiting for other futures.
+func AllOf[T any](futures ...TypedFuture[T]) TypedFuture[[]T] {
+	all := newFuture()
+	results := make([]T, len(futures))
+	remaining := int32(len(futures))
+	if remaining == 0 {
+		all.complete(results, nil)
+	}
+	for i, f := range futures {
+		f.OnComplete(func(result T, err error) {
+			if err != nil {
+				all.complete(nil, err)
+				return
+			}
+			results[i] = result
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				all.complete(results, nil)
+			}
+		})
+	}
+	return &typedFuture[[]T]{future: all}
+}
+
+// Return a Future of the first successful result. When all futures fail,
+// this fails with the error of the last failed future.
+func AnyOf[T any](futures ...TypedFuture[T]) TypedFuture[T] {
+	first := newFuture()
+	remaining := int32(len(futures))
+	if remaining == 0 {
+		first.complete(nil, ErrNoFuture)
+	}
+	for _, f := range futures {
+		f.OnComplete(func(result T, err error) {
+			if err == nil {
+				first.complete(result, nil)
+			} else if atomic.AddInt32(&remaining, -1) == 0 {
+				first.complete(nil, err)
+			}
+		})
+	}
+	return &typedFuture[T]{future: first}
+}
+
+// Run fn with the result of f as a new job on the Executor of f after f
+// finishes. When f fails, fn isn't called and the returned Future fails with
+// the same error.
+func Then[T, U any](f TypedFuture[T], fn func(result T) (U, error)) TypedFuture[U] {
+	next := newFuture()
+	next.ex = executorOf(f)
+	f.OnComplete(func(result T, err error) {
+		if err != nil {
+			next.complete(nil, err)
+			return
+		}
+		job := func() (Any, error) {
+			return fn(result)
+		}
+		if next.ex == nil {
+			next.complete(job())
+			return
+		}
+		// Don't block the worker which finished f while the queue is full.
+		go func() {
+			next.ex.Execute(job).OnComplete(next.complete)
+		}()
+	})
+	return &typedFuture[U]{future: next}
+}
+
+// Convert the result of f by fn when f finishes. fn is called from the
+// goroutine which finishes f, so it should be cheap.
+func Map[T, U any](f TypedFuture[T], fn func(result T) U) TypedFuture[U] {
+	mapped := newFuture()
+	mapped.ex = executorOf(f)
+	f.OnComplete(func(result T, err error) {
+		if err != nil {
+			mapped.complete(nil, err)
+			return
+		}
+		mapped.complete(fn(result), nil)
+	})
+	return &typedFuture[U]{future: mapped}
+}
+
+func executorOf(f any) Executor {
+	if withExecutor, ok := f.(interface{ executor() Executor }); ok {
+		return withExecutor.executor()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package goseq
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestAllOf(t *testing.T) {
+	ex := NewExecutor(2)
+	defer ex.Stop()
+	futures := make([]TypedFuture[int], 5)
+	for i := range futures {
+		futures[i] = Submit(ex, func() (int, error) {
+			return i, nil
+		})
+	}
+	results, err := AllOf(futures...).Result()
+	if err != nil || len(results) != 5 || results[0] != 0 || results[4] != 4 {
+		t.Error("AllOf should gather results in order.", results, err)
+	}
+}
+
+func TestAllOfFails(t *testing.T) {
+	failed := errors.New("failed")
+	f1 := newFuture()
+	f2 := newFuture()
+	all := AllOf[Any](f1, f2)
+	f2.complete(nil, failed)
+	if _, err := all.Result(); err != failed {
+		t.Error("AllOf should fail without waiting for other futures.", err)
+	}
+	f1.complete("finished", nil)
+}
+
+func TestAnyOf(t *testing.T) {
+	failed := errors.New("failed")
+	f1 := newFuture()
+	f2 := newFuture()
+	first := AnyOf[Any](f1, f2)
+	f1.complete(nil, failed)
+	f2.complete("finished", nil)
+	if res, err := first.Result(); res != "finished" || err != nil {
+		t.Error("AnyOf should return the first success.", res, err)
+	}
+	f3 := newFuture()
+	f3.complete(nil, failed)
+	if _, err := AnyOf[Any](f3).Result(); err != failed {
+		t.Error("AnyOf should fail when all futures fail.", err)
+	}
+	if _, err := AnyOf[Any]().Result(); err != ErrNoFuture {
+		t.Error("AnyOf without futures should fail.", err)
+	}
+}
+
+func TestThen(t *testing.T) {
+	ex := NewExecutor(1)
+	defer ex.Stop()
+	f := Submit(ex, func() (int, error) {
+		return 2, nil
+	})
+	next := Then(f, func(result int) (string, error) {
+		return strconv.Itoa(result * 3), nil
+	})
+	if res, err := next.Result(); res != "6" || err != nil {
+		t.Error("Then should run a job with the previous result.", res, err)
+	}
+}
+
+func TestThenFails(t *testing.T) {
+	failed := errors.New("failed")
+	f := newFuture()
+	called := false
+	next := Then[Any](f, func(result Any) (Any, error) {
+		called = true
+		return nil, nil
+	})
+	f.complete(nil, failed)
+	if _, err := next.Result(); err != failed || called {
+		t.Error("Then should not run a job after a failure.", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	ex := NewExecutor(1)
+	defer ex.Stop()
+	f := Submit(ex, func() (int, error) {
+		return 2, nil
+	})
+	mapped := Map(f, func(result int) int {
+		return result + 1
+	})
+	if res, err := mapped.Result(); res != 3 || err != nil {
+		t.Error("Map should convert a result.", res, err)
+	}
+}
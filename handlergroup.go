@@ -1,161 +1,420 @@
 /*
-   Package sequence is to run small tasks sequencially using Goroutine.
-
-   The current version of Go language cannot use generic type without type
-   assertion. So, this library doesn't manage efficient usage for context
-   instances. Instead of using a context instance, this library provides
-   'index' value to use a cache index. The provided index is
-   generated based on configured 'size' parameter for TaskManager and
-   it is reused after finishing a previous task which uses 'index'.
+   Package goseq is to run small tasks sequencially using Goroutine.
+
+   TaskManager passes each SequenceID to HandlerGroups with an 'index'
+   value to use a cache index. The provided index is generated based on
+   configured 'size' parameter for TaskManager and it is reused after
+   finishing a previous task which uses 'index', so payloads can be kept in
+   a preallocated slice without allocation for each task.
+   ContextTaskHandler receives a context which has values given to
+   TaskManager.PutContext(). NewSlotCodec() converts typed payloads in the
+   slice with a Codec[T] for journals and committers, and Submit() runs a
+   job on an Executor and returns a TypedFuture[T] for a typed result.
 */
 package goseq
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	channelBufferSize           = 512
-	stopCurrentHandlerGroupOnly = -1
-	stopAllHandlerGroups        = -2
+	// A handler yields this many times before sleeping to wait for a barrier.
+	spinCount     = 100
+	notStoppingID = math.MaxInt64
+	// A committer retries a failed range after a delay between these values.
+	minCommitRetryDelay = time.Millisecond
+	maxCommitRetryDelay = time.Second
 )
 
+// ErrHandlerTimeout is passed to ErrorHandler when a TaskHandler runs
+// longer than a timeout configured by HandlerGroup.SetTimeout().
+var ErrHandlerTimeout = errors.New("goseq: handler timed out")
+
+// ErrorHandler is called when a task fails for id. This can be called from
+// several goroutines at the same time.
+type ErrorHandler func(id SequenceID, index int, err error)
+
 // HandlerGroup is to manage several TaskHandler instances.
 // Added TaskHandlers are run at the same time when a new
 // SequenceID is put. After finishing all tasks for the SequenceID,
 // then next HandlerGroups are received the finished SequenceID.
+//
+// No SequenceID is passed through channels. Each handler goroutine
+// has its own Sequence and waits for a barrier Sequence. The barrier is
+// TaskManager's cursor for a first group and LastProcessedID of a previous
+// group for a group added by Then(). LastProcessedID is the minimum
+// Sequence of the group's handlers.
 type HandlerGroup interface {
 	AddHandler(handler TaskHandler, handlers ...TaskHandler)
 	AddHandlers(handlers []TaskHandler)
+	AddContextHandler(handler ContextTaskHandler, handlers ...ContextTaskHandler)
+	AddBatchHandler(handler BatchHandler)
 	Then(handler TaskHandler, handlers ...TaskHandler) HandlerGroup
+	ThenContext(handler ContextTaskHandler, handlers ...ContextTaskHandler) HandlerGroup
+	ThenBatch(handler BatchHandler) HandlerGroup
+	ThenCommit(w io.Writer, codec SlotCodec) HandlerGroup
+	SetTimeout(timeout time.Duration)
+	SetErrorHandler(handler ErrorHandler)
 	LastProcessedID() SequenceID
+	WaitFor(ctx context.Context, id SequenceID) (SequenceID, error)
 
 	start()
 	stop()
 	startAll()
 	stopAll()
+	stopAllAt(id SequenceID)
+	cancelAll()
 	waitStop()
 	waitStopAll()
+	abortAt(id SequenceID)
 
 	process(id SequenceID)
+	setBarrier(barrier Sequence)
 	addNextGroup(nextGroup HandlerGroup)
 	addNextGroups(nextGroup HandlerGroup, nextGroups ...HandlerGroup)
 
 	lastHandlerGroups() []HandlerGroup
+	allHandlerGroups() []HandlerGroup
+	handlerStates() []HandlerState
+	lastProcessedSequence() Sequence
+	rewind(id SequenceID)
 
 	numOfHandlers() int
 }
 
+// This is to track which SequenceID a handler goroutine is running.
+// currentID is updated for each SequenceID, so it is padded not to share
+// a cache line with states of other handlers. sequence is the last
+// SequenceID finished by the handler.
+type handlerState struct {
+	goroutineID int64
+	_           cacheLinePad
+	currentID   int64
+	_           cacheLinePad
+	sequence    *sequence
+}
+
 type handlerGroup struct {
 	name            string
 	nextGroups      []HandlerGroup
-	handlers        []TaskHandler
-	inChannels      []chan SequenceID
-	outChannels     []chan SequenceID
-	lastProcessedID Sequence
+	handlers        []ContextTaskHandler
+	batchHandlers   []BatchHandler
+	commits         []bool
+	states          []*handlerState
+	barrier         Sequence
+	lastProcessedID *sequence
+	stopID          int64
 	seqToIndexFunc  sequenceIDToIndexFunc
+	contexts        []context.Context
+	ctx             context.Context
+	cancel          context.CancelFunc
+	waitCtx         context.Context
+	wake            context.CancelFunc
+	abortCtx        context.Context
+	abort           context.CancelFunc
+	timeout         time.Duration
+	errorHandler    ErrorHandler
 	waitingStart    sync.WaitGroup
 	waitingStop     sync.WaitGroup
 }
 
 func newHandlerGroup(toIndexFunc sequenceIDToIndexFunc) (group *handlerGroup) {
 	group = new(handlerGroup)
-	group.handlers = make([]TaskHandler, 0, 2)
+	group.handlers = make([]ContextTaskHandler, 0, 2)
+	group.batchHandlers = make([]BatchHandler, 0)
+	group.commits = make([]bool, 0)
 	group.nextGroups = make([]HandlerGroup, 0, 2)
-	group.lastProcessedID = NewSequence()
+	group.barrier = NewSequence()
+	group.lastProcessedID = newSequence()
 	group.seqToIndexFunc = toIndexFunc
 	return
 }
 
+// Make SequenceIDs until id available for a group which doesn't have
+// a previous group and isn't added to a TaskManager.
 func (group *handlerGroup) process(id SequenceID) {
-	for _, ch := range group.inChannels {
-		ch <- id
-	}
+	group.barrier.Set(id)
+}
+
+// Set a Sequence which handlers of this group wait for.
+func (group *handlerGroup) setBarrier(barrier Sequence) {
+	group.barrier = barrier
 }
 
 // Add a TaskHandler or some TaskHandlers.
 func (group *handlerGroup) AddHandler(handler TaskHandler, handlers ...TaskHandler) {
-	group.handlers = append(group.handlers, handler)
-	if len(handlers) > 0 {
-		group.handlers = append(group.handlers, handlers...)
-	}
+	group.handlers = append(group.handlers, handler.withContext())
+	group.AddHandlers(handlers)
 }
 
 // Add TaskHandlers from an slice of TaskHandler.
 func (group *handlerGroup) AddHandlers(handlers []TaskHandler) {
-	if handlers != nil {
+	for _, handler := range handlers {
+		group.handlers = append(group.handlers, handler.withContext())
+	}
+}
+
+// Add a ContextTaskHandler or some ContextTaskHandlers.
+func (group *handlerGroup) AddContextHandler(handler ContextTaskHandler, handlers ...ContextTaskHandler) {
+	group.handlers = append(group.handlers, handler)
+	if len(handlers) > 0 {
 		group.handlers = append(group.handlers, handlers...)
 	}
 }
 
+// Add a BatchHandler. This runs with other handlers of this group.
+func (group *handlerGroup) AddBatchHandler(handler BatchHandler) {
+	group.batchHandlers = append(group.batchHandlers, handler)
+	group.commits = append(group.commits, false)
+}
+
+// Add a BatchHandler which commits payloads to w. Unlike other handlers, a
+// failed range isn't finished. See processBatchHandler().
+func (group *handlerGroup) addCommitter(w io.Writer, codec SlotCodec) {
+	group.batchHandlers = append(group.batchHandlers, newCommitHandler(w, codec, group.seqToIndexFunc))
+	group.commits = append(group.commits, true)
+}
+
 func (group *handlerGroup) addNextGroup(nextGroup HandlerGroup) {
+	nextGroup.setBarrier(group.lastProcessedID)
 	group.nextGroups = append(group.nextGroups, nextGroup)
 }
 
 func (group *handlerGroup) addNextGroups(nextGroup HandlerGroup, nextGroups ...HandlerGroup) {
-	group.nextGroups = append(group.nextGroups, nextGroup)
-	if nextGroups != nil {
-		group.nextGroups = append(group.nextGroups, nextGroups...)
+	group.addNextGroup(nextGroup)
+	for _, next := range nextGroups {
+		group.addNextGroup(next)
 	}
 }
 
-func (group *handlerGroup) processHandler(handler TaskHandler, inChannel <-chan SequenceID, outChannel chan<- SequenceID) {
+func (group *handlerGroup) processHandler(handler ContextTaskHandler, state *handlerState) {
+	atomic.StoreInt64(&state.goroutineID, currentGoroutineID())
 	group.waitingStart.Done()
 	defer group.waitingStop.Done()
 	for {
-		id := <-inChannel
-		if id == stopCurrentHandlerGroupOnly || id == stopAllHandlerGroups {
-			outChannel <- id
+		next := state.sequence.Get() + 1
+		available, ok := group.waitFor(next)
+		if !ok {
 			break
 		}
-		handler(id, group.seqToIndexFunc(id))
-		outChannel <- id
-		runtime.Gosched()
+		for id := next; id <= available; id++ {
+			atomic.StoreInt64(&state.currentID, int64(id))
+			group.runHandler(handler, id)
+		}
+		atomic.StoreInt64(&state.currentID, initialSequenceValue)
+		group.finish(state, available)
 	}
 }
 
-func (group *handlerGroup) sendToNextGroups() {
+// Pass all SequenceIDs which are available since the last call to handler
+// at once. When commit is true and handler fails, the range isn't finished
+// and is passed again after a delay. If it still fails after this group is
+// stopped, this gives up and next groups stop before the range.
+func (group *handlerGroup) processBatchHandler(handler BatchHandler, state *handlerState, commit bool) {
+	atomic.StoreInt64(&state.goroutineID, currentGoroutineID())
 	group.waitingStart.Done()
 	defer group.waitingStop.Done()
-	var id SequenceID
-	if len(group.outChannels) > 0 {
-		for {
-			// This loop expects all ids are the same.
-			for _, ch := range group.outChannels {
-				id = <-ch
-			}
-			if id == stopCurrentHandlerGroupOnly {
-				break
-			}
+	delay := minCommitRetryDelay
+	for {
+		next := state.sequence.Get() + 1
+		available, ok := group.waitFor(next)
+		if !ok {
+			break
+		}
+		atomic.StoreInt64(&state.currentID, int64(next))
+		err := group.runBatchHandler(handler, next, available)
+		atomic.StoreInt64(&state.currentID, initialSequenceValue)
+		if err == nil || !commit {
+			delay = minCommitRetryDelay
+			group.finish(state, available)
+			continue
+		}
+		if group.loadStopID() != notStoppingID {
 			for _, nextGroup := range group.nextGroups {
-				nextGroup.process(id)
-			}
-			if id == stopAllHandlerGroups {
-				break
+				nextGroup.abortAt(next - 1)
 			}
-			group.lastProcessedID.Set(id)
+			break
+		}
+		group.sleep(delay)
+		delay = min(delay*2, maxCommitRetryDelay)
+	}
+}
+
+// Sleep for d or until this group is stopped.
+func (group *handlerGroup) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-group.waitCtx.Done():
+	}
+}
+
+// Wait until the barrier reaches next and then return the available
+// SequenceID. This returns false when this group is stopped and all
+// SequenceIDs until the stop point are finished.
+func (group *handlerGroup) waitFor(next SequenceID) (SequenceID, bool) {
+	for i := 0; i < spinCount; i++ {
+		if available := group.barrier.Get(); available >= next {
+			return group.limitToStopID(available), next <= group.loadStopID()
+		}
+		runtime.Gosched()
+	}
+	for {
+		stopID := group.loadStopID()
+		if next > stopID {
+			return 0, false
+		}
+		ctx := group.waitCtx
+		if stopID != notStoppingID {
+			// SequenceIDs until stopID are already put, so this doesn't block
+			// forever unless a previous group gives up and lowers stopID.
+			ctx = group.abortCtx
+		}
+		// When stop is requested while waiting, waitCtx is cancelled and then
+		// the stop point is checked again.
+		available, err := group.barrier.WaitFor(ctx, next)
+		if err == nil {
+			return group.limitToStopID(available), true
+		}
+		if ctx == group.abortCtx {
+			runtime.Gosched()
 		}
 	}
 }
 
+func (group *handlerGroup) loadStopID() SequenceID {
+	return SequenceID(atomic.LoadInt64(&group.stopID))
+}
+
+func (group *handlerGroup) limitToStopID(id SequenceID) SequenceID {
+	if stopID := group.loadStopID(); id > stopID {
+		return stopID
+	}
+	return id
+}
+
+// Update the handler's Sequence and then move LastProcessedID forward to
+// the minimum Sequence of all handlers.
+func (group *handlerGroup) finish(state *handlerState, id SequenceID) {
+	state.sequence.Set(id)
+	minimum := id
+	for _, s := range group.states {
+		if v := s.sequence.Get(); v < minimum {
+			minimum = v
+		}
+	}
+	for {
+		current := group.lastProcessedID.get()
+		if int64(minimum) <= current || group.lastProcessedID.compareAndSet(current, int64(minimum)) {
+			return
+		}
+	}
+}
+
+func (group *handlerGroup) runHandler(handler ContextTaskHandler, id SequenceID) {
+	index := group.seqToIndexFunc(id)
+	group.invoke(group.handlerContext(index), id, index, func(ctx context.Context) error {
+		return handler(ctx, id, index)
+	})
+}
+
+func (group *handlerGroup) runBatchHandler(handler BatchHandler, from, to SequenceID) error {
+	index := group.seqToIndexFunc(from)
+	return group.invoke(group.handlerContext(index), from, index, func(ctx context.Context) error {
+		return handler(ctx, from, to)
+	})
+}
+
+// Call run with a timeout of this group and then pass an error to
+// ErrorHandler. The error of run is returned.
+func (group *handlerGroup) invoke(ctx context.Context, id SequenceID, index int, run func(ctx context.Context) error) error {
+	if group.timeout <= 0 {
+		err := run(ctx)
+		if err != nil {
+			group.handleError(id, index, err)
+		}
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, group.timeout)
+	defer cancel()
+	stopReport := context.AfterFunc(ctx, func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			group.handleError(id, index, ErrHandlerTimeout)
+		}
+	})
+	err := run(ctx)
+	timedOut := !stopReport() && ctx.Err() == context.DeadlineExceeded
+	if err != nil && !(timedOut && errors.Is(err, context.DeadlineExceeded)) {
+		group.handleError(id, index, err)
+	}
+	return err
+}
+
+// Create a context for a handler. This is cancelled after this group is
+// stopped, or by TaskManager.StopNow(), and has values of a context given to TaskManager.PutContext().
+func (group *handlerGroup) handlerContext(index int) context.Context {
+	if group.contexts == nil || group.contexts[index] == nil {
+		return group.ctx
+	}
+	return &putContext{Context: group.ctx, values: group.contexts[index]}
+}
+
+func (group *handlerGroup) handleError(id SequenceID, index int, err error) {
+	if group.errorHandler != nil {
+		group.errorHandler(id, index, err)
+	}
+}
+
 func (group *handlerGroup) start() {
-	length := len(group.handlers)
-	group.waitingStart.Add(length + 1)
-	group.waitingStop.Add(length + 1)
-	group.inChannels = make([]chan SequenceID, length)
-	group.outChannels = make([]chan SequenceID, length)
+	if group.states == nil {
+		group.initStates()
+	}
+	group.ctx, group.cancel = context.WithCancel(context.Background())
+	group.waitCtx, group.wake = context.WithCancel(context.Background())
+	group.abortCtx, group.abort = context.WithCancel(context.Background())
+	atomic.StoreInt64(&group.stopID, notStoppingID)
+	group.waitingStart.Add(len(group.states))
+	group.waitingStop.Add(len(group.states))
+	lastProcessedID := group.lastProcessedID.Get()
+	for _, state := range group.states {
+		state.sequence.Set(lastProcessedID)
+	}
 
 	for i, handler := range group.handlers {
-		group.inChannels[i] = make(chan SequenceID, channelBufferSize)
-		group.outChannels[i] = make(chan SequenceID, channelBufferSize)
-		go group.processHandler(handler, group.inChannels[i], group.outChannels[i])
+		go group.processHandler(handler, group.states[i])
+	}
+	for j, handler := range group.batchHandlers {
+		go group.processBatchHandler(handler, group.states[len(group.handlers)+j], group.commits[j])
+	}
+	if group.numOfHandlers() == 0 {
+		// A group without handlers passes SequenceIDs to next groups.
+		go group.processBatchHandler(func(ctx context.Context, from, to SequenceID) error {
+			return nil
+		}, group.states[0], false)
 	}
-
-	go group.sendToNextGroups()
 	group.waitingStart.Wait()
 }
 
+// States are created once, so monitors can read them after restarting.
+func (group *handlerGroup) initStates() {
+	length := group.numOfHandlers()
+	if length == 0 {
+		length = 1
+	}
+	group.states = make([]*handlerState, length)
+	for i := range group.states {
+		group.states[i] = &handlerState{currentID: initialSequenceValue, sequence: newSequence()}
+	}
+}
+
 func (group *handlerGroup) startAll() {
 	for _, nextGroup := range group.nextGroups {
 		nextGroup.startAll()
@@ -163,31 +422,71 @@ func (group *handlerGroup) startAll() {
 	group.start()
 }
 
+// Stop this group after finishing SequenceIDs which are available now.
 func (group *handlerGroup) stop() {
-	group.process(stopCurrentHandlerGroupOnly)
+	group.stopAt(group.barrier.Get())
 	group.waitStop()
 }
 
+// Stop this group and all next groups after finishing SequenceIDs which
+// are available now.
 func (group *handlerGroup) stopAll() {
-	group.process(stopAllHandlerGroups)
+	group.stopAllAt(group.barrier.Get())
 	group.waitStopAll()
 }
 
-func (group *handlerGroup) waitStop() {
-	group.waitingStop.Wait()
-	defer func() {
-		group.inChannels = nil
-		group.outChannels = nil
-	}()
+func (group *handlerGroup) stopAllAt(id SequenceID) {
+	group.stopAt(id)
+	for _, nextGroup := range group.nextGroups {
+		nextGroup.stopAllAt(id)
+	}
+}
 
-	for _, ch := range group.inChannels {
-		close(ch)
+// Set the stop point and then wake up handlers which are waiting for the
+// barrier. Contexts of handlers are not cancelled here, so SequenceIDs until
+// the stop point are handled with live contexts.
+func (group *handlerGroup) stopAt(id SequenceID) {
+	group.lowerStopID(id)
+	group.wake()
+}
+
+// Lower the stop point of this group and all next groups to id because a
+// previous group gave up SequenceIDs after id. This is called while stopping.
+func (group *handlerGroup) abortAt(id SequenceID) {
+	group.lowerStopID(id)
+	group.wake()
+	group.abort()
+	for _, nextGroup := range group.nextGroups {
+		nextGroup.abortAt(id)
 	}
-	for _, ch := range group.outChannels {
-		close(ch)
+}
+
+// The stop point only moves back, so stopAt() doesn't override abortAt().
+func (group *handlerGroup) lowerStopID(id SequenceID) {
+	for {
+		stopID := atomic.LoadInt64(&group.stopID)
+		if int64(id) >= stopID || atomic.CompareAndSwapInt64(&group.stopID, stopID, int64(id)) {
+			return
+		}
 	}
 }
 
+// Cancel contexts of handlers of this group and all next groups without
+// waiting for the stop point.
+func (group *handlerGroup) cancelAll() {
+	group.cancel()
+	for _, nextGroup := range group.nextGroups {
+		nextGroup.cancelAll()
+	}
+}
+
+// Wait for handlers to finish SequenceIDs until the stop point and then
+// cancel their contexts.
+func (group *handlerGroup) waitStop() {
+	group.waitingStop.Wait()
+	group.cancel()
+}
+
 func (group *handlerGroup) waitStopAll() {
 	group.waitStop()
 	for _, nextGroup := range group.nextGroups {
@@ -207,22 +506,119 @@ func (group *handlerGroup) lastHandlerGroups() []HandlerGroup {
 	return groups
 }
 
+// Return this group and all groups which are run after this group.
+func (group *handlerGroup) allHandlerGroups() []HandlerGroup {
+	groups := []HandlerGroup{group}
+	for _, next := range group.nextGroups {
+		groups = append(groups, next.allHandlerGroups()...)
+	}
+	return groups
+}
+
+// Return a snapshot which SequenceID each handler goroutine is running.
+func (group *handlerGroup) handlerStates() []HandlerState {
+	states := make([]HandlerState, len(group.states))
+	for i, state := range group.states {
+		states[i].GoroutineID = atomic.LoadInt64(&state.goroutineID)
+		states[i].ID = SequenceID(atomic.LoadInt64(&state.currentID))
+		states[i].Running = states[i].ID != initialSequenceValue
+	}
+	return states
+}
+
+// Return the Sequence behind LastProcessedID() to wait for this group.
+func (group *handlerGroup) lastProcessedSequence() Sequence {
+	return group.lastProcessedID
+}
+
+// Move LastProcessedID back to id. This is only for a stopped group.
+func (group *handlerGroup) rewind(id SequenceID) {
+	group.lastProcessedID.Set(id)
+}
+
 func (group *handlerGroup) numOfHandlers() int {
-	return len(group.handlers)
+	return len(group.handlers) + len(group.batchHandlers)
 }
 
 // Create a new HandlerGroup and then add new TaskHandler instances to the
 // new HandlerGroup. And then return the new handler. This new added handlers
 // are run after running current(group instance) HandlerGroup's TaskHandlers.
 func (group *handlerGroup) Then(handler TaskHandler, handlers ...TaskHandler) HandlerGroup {
-	newGroup := newHandlerGroup(group.seqToIndexFunc)
+	newGroup := group.newNextGroup()
 	newGroup.AddHandler(handler, handlers...)
+	return newGroup
+}
+
+// Create a new HandlerGroup with ContextTaskHandlers which are run after
+// this group's handlers like Then().
+func (group *handlerGroup) ThenContext(handler ContextTaskHandler, handlers ...ContextTaskHandler) HandlerGroup {
+	newGroup := group.newNextGroup()
+	newGroup.AddContextHandler(handler, handlers...)
+	return newGroup
+}
+
+// Create a new HandlerGroup with a BatchHandler which is run after this
+// group's handlers like Then().
+func (group *handlerGroup) ThenBatch(handler BatchHandler) HandlerGroup {
+	newGroup := group.newNextGroup()
+	newGroup.AddBatchHandler(handler)
+	return newGroup
+}
+
+// Create a new HandlerGroup which commits payloads to w after this group's
+// handlers. See TaskManager.AddCommitter() for details.
+func (group *handlerGroup) ThenCommit(w io.Writer, codec SlotCodec) HandlerGroup {
+	newGroup := group.newNextGroup()
+	newGroup.addCommitter(w, codec)
+	return newGroup
+}
+
+func (group *handlerGroup) newNextGroup() *handlerGroup {
+	newGroup := newHandlerGroup(group.seqToIndexFunc)
+	newGroup.contexts = group.contexts
+	newGroup.lastProcessedID.Set(group.lastProcessedID.Get())
 	group.addNextGroups(newGroup)
 	return newGroup
 }
 
+// Set a maximum duration to run each handler in this group. When a handler
+// runs longer than timeout, the handler's context is cancelled and then
+// ErrHandlerTimeout is passed to ErrorHandler. A handler cannot be
+// interrupted, so this group still waits the handler to return.
+// 0 means no timeout.
+func (group *handlerGroup) SetTimeout(timeout time.Duration) {
+	group.timeout = timeout
+}
+
+// Set an ErrorHandler to receive errors of this group. Errors are ignored
+// when no ErrorHandler is set.
+func (group *handlerGroup) SetErrorHandler(handler ErrorHandler) {
+	group.errorHandler = handler
+}
+
 // Get finished SequenceID. The returned value means that all tasks are finished
 // for the specific returned value or more smaller SequenceIDs.
 func (group *handlerGroup) LastProcessedID() SequenceID {
 	return group.lastProcessedID.Get()
 }
+
+// Block until all tasks are finished for id and then return the current
+// LastProcessedID. If ctx is done before that, ctx.Err() is returned.
+func (group *handlerGroup) WaitFor(ctx context.Context, id SequenceID) (SequenceID, error) {
+	return group.lastProcessedID.WaitFor(ctx, id)
+}
+
+// putContext is cancelled with a HandlerGroup and has values of a context
+// given to PutContext(). Values are looked up in the HandlerGroup's context
+// first so that derived contexts can find the group's cancellation.
+type putContext struct {
+	context.Context
+	values context.Context
+}
+
+func (ctx *putContext) Value(key interface{}) interface{} {
+	if v := ctx.Context.Value(key); v != nil {
+		return v
+	}
+	return ctx.values.Value(key)
+}
@@ -1,7 +1,10 @@
 package goseq
 
 import (
+	"context"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -86,11 +89,8 @@ func TestStartAndStop(t *testing.T) {
 	if group.LastProcessedID() != 1 {
 		t.Error("start/stop didn't process a request.")
 	}
-	if group.inChannels != nil {
-		t.Error("stop should close and clean inChannels.")
-	}
-	if group.outChannels != nil {
-		t.Error("stop should close and clean outChannels.")
+	if group.ctx.Err() == nil {
+		t.Error("stop should cancel contexts of handlers.")
 	}
 }
 
@@ -111,17 +111,8 @@ func TestStartAllAndStopAll(t *testing.T) {
 	if group2.LastProcessedID() != 1 {
 		t.Error("StartAll/StopAll didn't propagate to a next group.")
 	}
-	if group.inChannels != nil {
-		t.Error("stop should close and clean inChannels.")
-	}
-	if group.outChannels != nil {
-		t.Error("stop should close and clean outChannels.")
-	}
-	if group2.inChannels != nil {
-		t.Error("stop should close and clean inChannels.")
-	}
-	if group2.outChannels != nil {
-		t.Error("stop should close and clean outChannels.")
+	if group.ctx.Err() == nil || group2.ctx.Err() == nil {
+		t.Error("stopAll should cancel contexts of all groups.")
 	}
 }
 
@@ -136,15 +127,15 @@ func TestProcess(t *testing.T) {
 	}
 	group.AddHandler(handler)
 	group.start()
+	group.process(0)
 	group.process(1)
-	group.process(2)
 	group.stop()
 
 	if count != 2 {
 		t.Error("process call doesn't handle requests.")
 	}
 
-	if group.LastProcessedID() != 2 {
+	if group.LastProcessedID() != 1 {
 		t.Error("LastProcessedID is not updated well.")
 	}
 }
@@ -268,6 +259,93 @@ func TestSomeHandlersAndSomeNextGroups(t *testing.T) {
 	}
 }
 
+func TestWaitForHandlerGroup(t *testing.T) {
+	group := newHandlerGroup(sampleToIndexFunc)
+	handler := func(id SequenceID, index int) {
+		time.Sleep(time.Millisecond)
+	}
+	group.AddHandler(handler)
+	group.start()
+	defer group.stop()
+	group.process(0)
+	group.process(1)
+	id, err := group.WaitFor(context.Background(), 1)
+	if err != nil || id != 1 {
+		t.Error("WaitFor should block until id is processed. id:", id)
+	}
+	if group.LastProcessedID() != 1 {
+		t.Error("LastProcessedID should be updated after WaitFor.")
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+	var m sync.Mutex
+	errs := make(map[SequenceID]error)
+	group := newHandlerGroup(sampleToIndexFunc)
+	group.AddHandler(func(id SequenceID, index int) {
+		if id == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+	group.SetTimeout(5 * time.Millisecond)
+	group.SetErrorHandler(func(id SequenceID, index int, err error) {
+		m.Lock()
+		defer m.Unlock()
+		errs[id] = err
+	})
+	group.start()
+	group.process(0)
+	group.process(1)
+	group.WaitFor(context.Background(), 1)
+	group.stop()
+
+	m.Lock()
+	defer m.Unlock()
+	if len(errs) != 1 || errs[1] != ErrHandlerTimeout {
+		t.Error("A slow handler should report ErrHandlerTimeout. errs:", errs)
+	}
+	if group.LastProcessedID() != 1 {
+		t.Error("A slow handler should not stop processing.")
+	}
+}
+
+func TestAddBatchHandler(t *testing.T) {
+	var m sync.Mutex
+	expected := SequenceID(0)
+	group := newHandlerGroup(sampleToIndexFunc)
+	group.AddBatchHandler(func(ctx context.Context, from, to SequenceID) error {
+		m.Lock()
+		defer m.Unlock()
+		if from != expected || to < from {
+			t.Error("BatchHandler should receive continuous ids.", from, to)
+		}
+		expected = to + 1
+		return nil
+	})
+	group.start()
+	for i := 0; i < 100; i++ {
+		group.process(SequenceID(i))
+	}
+	group.stop()
+	if expected != 100 || group.LastProcessedID() != 99 {
+		t.Error("BatchHandler should receive all ids. expected:", expected)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	group := newHandlerGroup(sampleToIndexFunc)
+	group.AddHandler(func(id SequenceID, index int) {}, func(id SequenceID, index int) {})
+	group.initStates()
+	group.finish(group.states[0], 3)
+	if group.LastProcessedID() != -1 {
+		t.Error("finish should wait for other handlers.", group.LastProcessedID())
+	}
+	group.finish(group.states[1], 5)
+	if group.LastProcessedID() != 3 {
+		t.Error("finish should move LastProcessedID to the slowest handler.", group.LastProcessedID())
+	}
+}
+
 func BenchmarkProcess(b *testing.B) {
 	group := newHandlerGroup(sampleToIndexFunc)
 	seq := NewSequence()
@@ -306,3 +384,43 @@ func BenchmarkSomeGroups(b *testing.B) {
 	}
 	groups[0].stopAll()
 }
+
+// This is the layout of sequence before padding.
+type unpaddedSequence struct {
+	value int64
+}
+
+// Each goroutine updates its own value like handlers update their
+// lastProcessedID. Values next to each other slow down without padding.
+func benchmarkUpdateValues(b *testing.B, values []*int64) {
+	var wg sync.WaitGroup
+	wg.Add(len(values))
+	b.ResetTimer()
+	for _, value := range values {
+		go func(v *int64) {
+			defer wg.Done()
+			for j := 0; j < b.N; j++ {
+				atomic.AddInt64(v, 1)
+			}
+		}(value)
+	}
+	wg.Wait()
+}
+
+func BenchmarkPaddedSequences(b *testing.B) {
+	seqs := make([]sequence, max(runtime.GOMAXPROCS(0), 2))
+	values := make([]*int64, len(seqs))
+	for i := range seqs {
+		values[i] = &seqs[i].value
+	}
+	benchmarkUpdateValues(b, values)
+}
+
+func BenchmarkUnpaddedSequences(b *testing.B) {
+	seqs := make([]unpaddedSequence, max(runtime.GOMAXPROCS(0), 2))
+	values := make([]*int64, len(seqs))
+	for i := range seqs {
+		values[i] = &seqs[i].value
+	}
+	benchmarkUpdateValues(b, values)
+}
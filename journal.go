@@ -0,0 +1,301 @@
+package goseq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	journalSuffix       = ".journal"
+	journalHeaderSize   = 16
+	defaultSegmentSize  = 64 * 1024 * 1024
+	maxJournalEntrySize = 1 << 30
+)
+
+// ErrJournalSequence is returned when SequenceIDs in a Journal are not
+// continuous.
+var ErrJournalSequence = errors.New("goseq: journal sequence is not continuous")
+
+// ErrNoJournal is returned by TaskManager.Replay() without a Journal.
+var ErrNoJournal = errors.New("goseq: no journal")
+
+// SlotCodec converts a payload in a slot to bytes and back. Payloads are kept
+// by an application in a slice and index is given by TaskHandler to access it.
+// NewSlotCodec() creates a SlotCodec from a Codec and the slice.
+type SlotCodec interface {
+	Marshal(id SequenceID, index int) ([]byte, error)
+	Unmarshal(id SequenceID, index int, data []byte) error
+}
+
+// Journal is a write-ahead log of payloads for each SequenceID. Entries
+// must be appended in the order of SequenceIDs without a gap. data passed
+// to a handler of Read() is reused for the next entry, so copy it to keep
+// it after the handler returns.
+type Journal interface {
+	Append(id SequenceID, data []byte) error
+	Last() SequenceID
+	Read(from SequenceID, handler func(id SequenceID, data []byte) error) error
+	Sync() error
+	Close() error
+}
+
+type journal struct {
+	lock        sync.Mutex
+	dir         string
+	segmentSize int64
+	segments    []SequenceID
+	file        *os.File
+	fileSize    int64
+	last        SequenceID
+	buf         []byte
+	closed      bool
+}
+
+// Open a Journal which writes entries to segment files in dir. A new segment
+// file is created when the current one becomes larger than segmentSize.
+// 0 means a default size. A broken entry at the end of the last segment is
+// truncated, so a Journal can be reopened after a crash.
+func OpenJournal(dir string, segmentSize int64) (Journal, error) {
+	return openJournal(dir, segmentSize)
+}
+
+func openJournal(dir string, segmentSize int64) (j *journal, err error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	j = new(journal)
+	j.dir = dir
+	j.segmentSize = segmentSize
+	j.last = initialSequenceValue
+	if j.segments, err = listSegments(dir); err != nil {
+		return nil, err
+	}
+	if len(j.segments) > 0 {
+		if err = j.openLastSegment(); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+func listSegments(dir string) ([]SequenceID, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]SequenceID, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, journalSuffix) {
+			continue
+		}
+		first, err := strconv.ParseInt(strings.TrimSuffix(name, journalSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, SequenceID(first))
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func (j *journal) segmentPath(first SequenceID) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%020d%s", first, journalSuffix))
+}
+
+// Find the last entry and then truncate a broken entry after it.
+func (j *journal) openLastSegment() error {
+	first := j.segments[len(j.segments)-1]
+	file, err := os.OpenFile(j.segmentPath(first), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	j.last = first - 1
+	var offset int64
+	err = readEntries(file, func(id SequenceID, data []byte) error {
+		j.last = id
+		offset += journalHeaderSize + int64(len(data))
+		return nil
+	})
+	if err == nil {
+		err = file.Truncate(offset)
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file = file
+	j.fileSize = offset
+	return nil
+}
+
+// Read entries until EOF or a broken entry. data passed to handler is
+// overwritten by the next entry.
+func readEntries(r io.Reader, handler func(id SequenceID, data []byte) error) error {
+	var header [journalHeaderSize]byte
+	var data []byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil
+		}
+		id := SequenceID(binary.LittleEndian.Uint64(header[0:]))
+		size := binary.LittleEndian.Uint32(header[8:])
+		if size > maxJournalEntrySize {
+			return nil
+		}
+		if cap(data) < int(size) {
+			data = make([]byte, size)
+		}
+		data = data[:size]
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil
+		}
+		crc := crc32.Update(crc32.ChecksumIEEE(header[:12]), crc32.IEEETable, data)
+		if crc != binary.LittleEndian.Uint32(header[12:]) {
+			return nil
+		}
+		if err := handler(id, data); err != nil {
+			return err
+		}
+	}
+}
+
+// Append data for id. id must be the next SequenceID of Last() except
+// the first entry of an empty Journal. os.ErrClosed is returned after
+// Close().
+func (j *journal) Append(id SequenceID, data []byte) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.closed {
+		return os.ErrClosed
+	}
+	if len(j.segments) > 0 && id != j.last+1 {
+		return ErrJournalSequence
+	}
+	if j.file == nil || j.fileSize >= j.segmentSize {
+		if err := j.rotate(id); err != nil {
+			return err
+		}
+	}
+	j.buf = appendEntry(j.buf[:0], id, data)
+	if _, err := j.file.Write(j.buf); err != nil {
+		return err
+	}
+	j.fileSize += int64(len(j.buf))
+	j.last = id
+	return nil
+}
+
+// Encode an entry with a header which has id, length and a checksum.
+func appendEntry(buf []byte, id SequenceID, data []byte) []byte {
+	var header [journalHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:], uint64(id))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(data)))
+	crc := crc32.Update(crc32.ChecksumIEEE(header[:12]), crc32.IEEETable, data)
+	binary.LittleEndian.PutUint32(header[12:], crc)
+	buf = append(buf, header[:]...)
+	return append(buf, data...)
+}
+
+// Create a new segment file which starts with first.
+func (j *journal) rotate(first SequenceID) error {
+	if j.file != nil {
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.file = nil
+	}
+	file, err := os.OpenFile(j.segmentPath(first), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err = syncDir(j.dir); err != nil {
+		file.Close()
+		return err
+	}
+	j.file = file
+	j.fileSize = 0
+	j.segments = append(j.segments, first)
+	return nil
+}
+
+// Return the last appended SequenceID.
+func (j *journal) Last() SequenceID {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.last
+}
+
+// Call handler for each entry from 'from' in the order of SequenceIDs.
+// data is valid only until handler returns.
+func (j *journal) Read(from SequenceID, handler func(id SequenceID, data []byte) error) error {
+	j.lock.Lock()
+	segments := append([]SequenceID(nil), j.segments...)
+	j.lock.Unlock()
+	for i, first := range segments {
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+		file, err := os.Open(j.segmentPath(first))
+		if err != nil {
+			return err
+		}
+		err = readEntries(file, func(id SequenceID, data []byte) error {
+			if id < from {
+				return nil
+			}
+			return handler(id, data)
+		})
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush appended entries to a disk. os.ErrClosed is returned after Close().
+func (j *journal) Sync() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.closed {
+		return os.ErrClosed
+	}
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.closed = true
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	j.file = nil
+	return err
+}
@@ -0,0 +1,206 @@
+package goseq
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type testSlotCodec struct {
+	slots []string
+}
+
+func (codec *testSlotCodec) Marshal(id SequenceID, index int) ([]byte, error) {
+	return []byte(codec.slots[index]), nil
+}
+
+func (codec *testSlotCodec) Unmarshal(id SequenceID, index int, data []byte) error {
+	codec.slots[index] = string(data)
+	return nil
+}
+
+func readAllEntries(j Journal, from SequenceID) (ids []SequenceID, values []string) {
+	j.Read(from, func(id SequenceID, data []byte) error {
+		ids = append(ids, id)
+		values = append(values, string(data))
+		return nil
+	})
+	return
+}
+
+func TestJournalAppendAndRead(t *testing.T) {
+	j, err := OpenJournal(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal("OpenJournal failed.", err)
+	}
+	defer j.Close()
+	if j.Last() != initialSequenceValue {
+		t.Error("Last should return initialSequenceValue for an empty journal.")
+	}
+	for i := 0; i < 5; i++ {
+		if err := j.Append(SequenceID(i), []byte(strconv.Itoa(i))); err != nil {
+			t.Error("Append failed.", err)
+		}
+	}
+	if j.Last() != 4 {
+		t.Error("Last should return the last appended id.")
+	}
+	ids, values := readAllEntries(j, 2)
+	if len(ids) != 3 || ids[0] != 2 || values[2] != "4" {
+		t.Error("Read should return entries from 'from'.", ids, values)
+	}
+	if err := j.Append(6, nil); err != ErrJournalSequence {
+		t.Error("Append should reject a gap. err:", err)
+	}
+}
+
+func TestJournalAppendAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, 0)
+	for i := 0; i < 3; i++ {
+		j.Append(SequenceID(i), []byte(strconv.Itoa(i)))
+	}
+	j.Close()
+	if err := j.Append(0, []byte("overwritten")); err != os.ErrClosed {
+		t.Error("Append should return os.ErrClosed after Close.", err)
+	}
+	j, _ = OpenJournal(dir, 0)
+	defer j.Close()
+	if ids, _ := readAllEntries(j, 0); len(ids) != 3 || j.Last() != 2 {
+		t.Error("Append after Close should not change segments.", ids, j.Last())
+	}
+	if err := j.Append(5, nil); err != ErrJournalSequence {
+		t.Error("Append should check continuity of a reopened journal.", err)
+	}
+}
+
+func TestJournalSegments(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, 64)
+	for i := 10; i < 30; i++ {
+		j.Append(SequenceID(i), []byte("0123456789"))
+	}
+	j.Close()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) < 2 {
+		t.Error("Journal should create some segments. segments:", len(entries))
+	}
+
+	j, err := OpenJournal(dir, 64)
+	if err != nil {
+		t.Fatal("OpenJournal failed to reopen.", err)
+	}
+	defer j.Close()
+	if j.Last() != 29 {
+		t.Error("A reopened journal should have the last id. last:", j.Last())
+	}
+	ids, _ := readAllEntries(j, 15)
+	if len(ids) != 15 || ids[0] != 15 || ids[14] != 29 {
+		t.Error("Read should read entries over segments.", ids)
+	}
+	if err := j.Append(30, nil); err != nil {
+		t.Error("Append should continue after reopening.", err)
+	}
+}
+
+func TestJournalTruncateBrokenEntry(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := openJournal(dir, 0)
+	j.Append(0, []byte("first"))
+	j.Append(1, []byte("second"))
+	path := j.file.Name()
+	j.Close()
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+
+	j2, err := OpenJournal(dir, 0)
+	if err != nil {
+		t.Fatal("OpenJournal failed.", err)
+	}
+	defer j2.Close()
+	if j2.Last() != 0 {
+		t.Error("A broken entry should be ignored. last:", j2.Last())
+	}
+	if err := j2.Append(1, []byte("again")); err != nil {
+		t.Error("Append should overwrite a broken entry.", err)
+	}
+	_, values := readAllEntries(j2, 0)
+	if len(values) != 2 || values[1] != "again" {
+		t.Error("Journal should have rewritten entries.", values)
+	}
+}
+
+func TestSetJournalAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, 0)
+	codec := &testSlotCodec{slots: make([]string, 4)}
+	tm := NewTaskManager(4)
+	tm.AddHandler(func(id SequenceID, index int) {})
+	tm.SetJournal(j, codec)
+	tm.SetErrorHandler(func(id SequenceID, index int, err error) {
+		t.Error("Journal failed.", err)
+	})
+	tm.Start()
+	for i := 0; i < 10; i++ {
+		tm.Put(func(id SequenceID, index int) {
+			codec.slots[index] = fmt.Sprint("value", id)
+		})
+	}
+	tm.Stop()
+	j.Close()
+
+	j, _ = OpenJournal(dir, 0)
+	defer j.Close()
+	var m sync.Mutex
+	replayed := make(map[SequenceID]string)
+	codec = &testSlotCodec{slots: make([]string, 4)}
+	tm = NewTaskManager(4)
+	group := tm.AddHandler(func(id SequenceID, index int) {
+		m.Lock()
+		defer m.Unlock()
+		replayed[id] = codec.slots[index]
+	})
+	tm.SetJournal(j, codec)
+	tm.Start()
+	if err := tm.Replay(3); err != nil {
+		t.Error("Replay failed.", err)
+	}
+	if id := tm.Put(nil); id != 10 {
+		t.Error("Put should continue after replayed ids. id:", id)
+	}
+	tm.Stop()
+
+	if len(replayed) != 8 || replayed[3] != "value3" || replayed[9] != "value9" {
+		t.Error("Replay should put journaled payloads with original ids.", replayed)
+	}
+	if group.LastProcessedID() != 10 || j.Last() != 10 {
+		t.Error("A new id should be journaled after replaying.", j.Last())
+	}
+}
+
+func TestJournalWithoutReplay(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := OpenJournal(dir, 0)
+	for i := 0; i < 3; i++ {
+		j.Append(SequenceID(i), []byte("old"))
+	}
+	codec := &testSlotCodec{slots: make([]string, 4)}
+	errs := make([]error, 0, 1)
+	tm := NewTaskManager(4)
+	tm.AddHandler(func(id SequenceID, index int) {})
+	tm.SetJournal(j, codec)
+	tm.SetErrorHandler(func(id SequenceID, index int, err error) {
+		errs = append(errs, err)
+	})
+	tm.Start()
+	tm.Put(func(id SequenceID, index int) {
+		codec.slots[index] = "new"
+	})
+	tm.Stop()
+	j.Close()
+	if len(errs) != 1 || errs[0] != ErrJournalSequence {
+		t.Error("Put should report an id which doesn't follow the journal.", errs)
+	}
+}
@@ -0,0 +1,85 @@
+package goseq
+
+import (
+	"sync"
+	"time"
+)
+
+// LagHandler is called when a lag of group crosses a water mark.
+// lag is the number of SequenceIDs which are put to TaskManager but
+// not processed by group yet.
+type LagHandler func(group HandlerGroup, lag SequenceID)
+
+// monitor is a background task which runs between TaskManager's
+// Start() and Stop().
+type monitor interface {
+	start()
+	stop()
+}
+
+type lagMonitor struct {
+	cursor      Sequence
+	groups      func() []HandlerGroup
+	interval    time.Duration
+	high        SequenceID
+	low         SequenceID
+	onHigh      LagHandler
+	onLow       LagHandler
+	lagging     map[HandlerGroup]bool
+	stopChannel chan bool
+	waitingStop sync.WaitGroup
+}
+
+func newLagMonitor(cursor Sequence, interval time.Duration, onHigh, onLow LagHandler) (monitor *lagMonitor) {
+	monitor = new(lagMonitor)
+	monitor.cursor = cursor
+	monitor.interval = interval
+	monitor.onHigh = onHigh
+	monitor.onLow = onLow
+	monitor.lagging = make(map[HandlerGroup]bool)
+	return
+}
+
+func (monitor *lagMonitor) start() {
+	monitor.stopChannel = make(chan bool)
+	monitor.waitingStop.Add(1)
+	go monitor.run(monitor.groups())
+}
+
+func (monitor *lagMonitor) stop() {
+	close(monitor.stopChannel)
+	monitor.waitingStop.Wait()
+}
+
+func (monitor *lagMonitor) run(groups []HandlerGroup) {
+	defer monitor.waitingStop.Done()
+	ticker := time.NewTicker(monitor.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, group := range groups {
+				monitor.check(group)
+			}
+		case <-monitor.stopChannel:
+			return
+		}
+	}
+}
+
+// Compare the current lag of group with water marks. A handler is called
+// only when the state is changed, so onHigh and onLow are called alternately.
+func (monitor *lagMonitor) check(group HandlerGroup) {
+	lag := monitor.cursor.Get() - group.LastProcessedID()
+	if !monitor.lagging[group] && lag >= monitor.high {
+		monitor.lagging[group] = true
+		if monitor.onHigh != nil {
+			monitor.onHigh(group, lag)
+		}
+	} else if monitor.lagging[group] && lag <= monitor.low {
+		monitor.lagging[group] = false
+		if monitor.onLow != nil {
+			monitor.onLow(group, lag)
+		}
+	}
+}
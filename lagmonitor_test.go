@@ -0,0 +1,65 @@
+package goseq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLagMonitorCheck(t *testing.T) {
+	highCount, lowCount := 0, 0
+	cursor := NewSequence()
+	group := newHandlerGroup(sampleToIndexFunc)
+	monitor := newLagMonitor(cursor, time.Millisecond,
+		func(group HandlerGroup, lag SequenceID) { highCount++ },
+		func(group HandlerGroup, lag SequenceID) { lowCount++ })
+	monitor.high = 4
+	monitor.low = 1
+
+	cursor.Set(2)
+	monitor.check(group)
+	if highCount != 0 {
+		t.Error("onHigh should not be called before reaching high.")
+	}
+	cursor.Set(3)
+	monitor.check(group)
+	monitor.check(group)
+	if highCount != 1 {
+		t.Error("onHigh should be called once after reaching high. count:", highCount)
+	}
+	group.lastProcessedID.Set(1)
+	monitor.check(group)
+	if lowCount != 0 {
+		t.Error("onLow should not be called before falling to low.")
+	}
+	group.lastProcessedID.Set(2)
+	monitor.check(group)
+	monitor.check(group)
+	if lowCount != 1 {
+		t.Error("onLow should be called once after falling to low. count:", lowCount)
+	}
+}
+
+func TestMonitorLag(t *testing.T) {
+	highChannel := make(chan SequenceID, 1)
+	lowChannel := make(chan SequenceID, 1)
+	release := make(chan bool)
+	tm := NewTaskManager(8)
+	tm.AddHandler(func(id SequenceID, index int) {
+		<-release
+	})
+	tm.MonitorLag(0.5, 0.25, time.Millisecond,
+		func(group HandlerGroup, lag SequenceID) { highChannel <- lag },
+		func(group HandlerGroup, lag SequenceID) { lowChannel <- lag })
+	tm.Start()
+	defer tm.Stop()
+	for i := 0; i < 5; i++ {
+		tm.Put(nil)
+	}
+	if lag := <-highChannel; lag < 4 {
+		t.Error("onHigh should be called with a lag over high water mark. lag:", lag)
+	}
+	close(release)
+	if lag := <-lowChannel; lag > 2 {
+		t.Error("onLow should be called with a lag under low water mark. lag:", lag)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package goseq
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	minPollInterval    = time.Microsecond
+	maxPollInterval    = time.Millisecond
+	mmapProtection     = syscall.PROT_READ | syscall.PROT_WRITE
+	mmapSharedMapping  = syscall.MAP_SHARED
+	msyncSynchronously = 4 // MS_SYNC
+)
+
+// Map a whole file which has size bytes to memory shared with other processes.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, size, mmapProtection, mmapSharedMapping)
+}
+
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+// Flush changes of mapped memory to a file.
+func syncMapping(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), msyncSynchronously)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Return an int64 at offset of mapped memory. offset must be 8 bytes aligned.
+func int64At(data []byte, offset int) *int64 {
+	return (*int64)(unsafe.Pointer(&data[offset]))
+}
+
+// mmapSequence is a Sequence in memory mapped from a file. Other processes
+// can update the value, so WaitFor() polls the value instead of waiting
+// for a notification.
+type mmapSequence struct {
+	value *int64
+}
+
+func newMmapSequence(value *int64) *mmapSequence {
+	return &mmapSequence{value: value}
+}
+
+func (seq *mmapSequence) Get() SequenceID {
+	return SequenceID(atomic.LoadInt64(seq.value))
+}
+
+func (seq *mmapSequence) Set(newSequenceID SequenceID) {
+	atomic.StoreInt64(seq.value, int64(newSequenceID))
+}
+
+func (seq *mmapSequence) Next() SequenceID {
+	return SequenceID(atomic.AddInt64(seq.value, 1))
+}
+
+// Poll the value until it becomes target or larger. The interval starts
+// from minPollInterval and doubles until maxPollInterval.
+func (seq *mmapSequence) WaitFor(ctx context.Context, target SequenceID) (SequenceID, error) {
+	interval := minPollInterval
+	var timer *time.Timer
+	for {
+		if current := seq.Get(); current >= target {
+			return current, nil
+		}
+		if timer == nil {
+			timer = time.NewTimer(interval)
+			defer timer.Stop()
+		} else {
+			timer.Reset(interval)
+		}
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return seq.Get(), ctx.Err()
+		}
+		if interval < maxPollInterval {
+			interval *= 2
+		}
+	}
+}
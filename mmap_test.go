@@ -0,0 +1,39 @@
+//go:build linux
+
+package goseq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMmapSequence(t *testing.T) {
+	var value int64 = initialSequenceValue
+	seq := newMmapSequence(&value)
+	if seq.Next() != 0 || seq.Get() != 0 {
+		t.Error("Next should increment a mapped value.")
+	}
+	seq.Set(5)
+	if value != 5 {
+		t.Error("Set should write a mapped value.")
+	}
+}
+
+func TestMmapSequenceWaitFor(t *testing.T) {
+	var value int64 = initialSequenceValue
+	seq := newMmapSequence(&value)
+	go func() {
+		time.Sleep(time.Millisecond)
+		seq.Set(3)
+	}()
+	id, err := seq.WaitFor(context.Background(), 2)
+	if err != nil || id != 3 {
+		t.Error("WaitFor should poll until reaching target.", id, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := seq.WaitFor(ctx, 10); err != context.DeadlineExceeded {
+		t.Error("WaitFor should return ctx.Err(). err:", err)
+	}
+}
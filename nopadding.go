@@ -0,0 +1,7 @@
+//go:build nopadding
+
+package goseq
+
+// cacheLinePad is empty with the nopadding tag, so benchmarks show the cost
+// of false sharing.
+type cacheLinePad struct{}
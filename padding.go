@@ -0,0 +1,8 @@
+//go:build !nopadding
+
+package goseq
+
+// cacheLinePad keeps a value apart from other values to avoid false sharing
+// between CPU cores. Build with the nopadding tag to compare benchmarks
+// without padding.
+type cacheLinePad [cacheLineSize - 8]byte
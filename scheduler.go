@@ -0,0 +1,342 @@
+package goseq
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInvalidPeriod is returned by Future.Result() for a periodic job
+// scheduled with a period which isn't positive.
+var ErrInvalidPeriod = errors.New("goseq: period must be positive")
+
+// Clock is a source of time for ScheduledExecutor. WaitUntil returns a
+// channel which receives the current time after the clock reaches t. Tests
+// can use ManualClock to move time without sleeping.
+type Clock interface {
+	Now() time.Time
+	WaitUntil(t time.Time) <-chan time.Time
+}
+
+// ScheduledExecutor runs jobs on an Executor after a delay or periodically.
+// Shutdown() and ShutdownNow() also cancel scheduled jobs which are not
+// started yet.
+type ScheduledExecutor interface {
+	Executor
+	Schedule(delay time.Duration, job Job) ScheduledFuture
+	ScheduleAtFixedRate(initialDelay, period time.Duration, job Job) ScheduledFuture
+	ScheduleWithFixedDelay(initialDelay, delay time.Duration, job Job) ScheduledFuture
+}
+
+// ScheduledFuture is a Future of a scheduled job. A Future of a periodic
+// job finishes only when it is cancelled or a run fails. Cancel() returns
+// false when the Future has already finished.
+type ScheduledFuture interface {
+	Future
+	Cancel() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) WaitUntil(t time.Time) <-chan time.Time {
+	return time.After(time.Until(t))
+}
+
+// ManualClock is a Clock which moves only by Advance().
+type ManualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) WaitUntil(t time.Time) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if t.After(c.now) {
+		c.waiters = append(c.waiters, manualWaiter{at: t, ch: ch})
+	} else {
+		ch <- c.now
+	}
+	return ch
+}
+
+// Move the clock forward by d and wake goroutines waiting until then.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			waiters = append(waiters, waiter)
+		} else {
+			waiter.ch <- c.now
+		}
+	}
+	c.waiters = waiters
+}
+
+type scheduleKind int
+
+const (
+	oneShot scheduleKind = iota
+	fixedRate
+	fixedDelay
+)
+
+// A job waits in queue until at. period is used by fixedRate and fixedDelay.
+// index is -1 while the job isn't in queue.
+type scheduledTask struct {
+	*future
+	scheduler *scheduledExecutor
+	job       Job
+	kind      scheduleKind
+	at        time.Time
+	period    time.Duration
+	index     int
+	cancelled bool
+}
+
+type scheduledQueue []*scheduledTask
+
+type scheduledExecutor struct {
+	Executor
+	lock     sync.Mutex
+	clock    Clock
+	queue    scheduledQueue
+	wake     chan struct{}
+	stopping bool
+	stopped  chan struct{}
+}
+
+// Create a ScheduledExecutor which runs jobs on ex. clock nil uses the
+// system clock.
+func NewScheduledExecutor(ex Executor, clock Clock) ScheduledExecutor {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	s := &scheduledExecutor{
+		Executor: ex,
+		clock:    clock,
+		wake:     make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Run job once after delay.
+func (s *scheduledExecutor) Schedule(delay time.Duration, job Job) ScheduledFuture {
+	return s.schedule(oneShot, delay, 0, job)
+}
+
+// Run job after initialDelay and then every period from the previous
+// scheduled time. A run which is late starts just after the previous run.
+// Runs never overlap. The Future fails with ErrInvalidPeriod when period
+// isn't positive.
+func (s *scheduledExecutor) ScheduleAtFixedRate(initialDelay, period time.Duration, job Job) ScheduledFuture {
+	return s.schedule(fixedRate, initialDelay, period, job)
+}
+
+// Run job after initialDelay and then after delay from the end of each run.
+// The Future fails with ErrInvalidPeriod when delay isn't positive.
+func (s *scheduledExecutor) ScheduleWithFixedDelay(initialDelay, delay time.Duration, job Job) ScheduledFuture {
+	return s.schedule(fixedDelay, initialDelay, delay, job)
+}
+
+func (s *scheduledExecutor) schedule(kind scheduleKind, delay, period time.Duration, job Job) ScheduledFuture {
+	t := &scheduledTask{future: newFuture(), scheduler: s, job: job, kind: kind, period: period, index: -1}
+	t.future.ex = s
+	if kind != oneShot && period <= 0 {
+		t.future.complete(nil, ErrInvalidPeriod)
+		return t
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopping {
+		t.future.complete(nil, ErrExecutorShutdown)
+		return t
+	}
+	t.at = s.clock.Now().Add(delay)
+	s.push(t)
+	return t
+}
+
+// This must be called with the lock.
+func (s *scheduledExecutor) push(t *scheduledTask) {
+	heap.Push(&s.queue, t)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wait for the first job in queue and pass it to the Executor. A pending
+// wait is reused unless the first job is due before it, so each wake doesn't
+// leave another waiter in the Clock.
+func (s *scheduledExecutor) run() {
+	var timer <-chan time.Time
+	var timerAt time.Time
+	for {
+		s.lock.Lock()
+		if len(s.queue) > 0 {
+			first := s.queue[0]
+			if !first.at.After(s.clock.Now()) {
+				heap.Pop(&s.queue)
+				s.lock.Unlock()
+				s.start(first)
+				continue
+			}
+			if timer == nil || first.at.Before(timerAt) {
+				timer, timerAt = s.clock.WaitUntil(first.at), first.at
+			}
+		}
+		s.lock.Unlock()
+		select {
+		case <-timer:
+			timer = nil
+		case <-s.wake:
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+// Pass t to the Executor. t is checked under the lock again when a worker
+// starts it, so a job cancelled after it leaves queue doesn't run.
+func (s *scheduledExecutor) start(t *scheduledTask) {
+	s.Executor.Execute(func() (Any, error) {
+		s.lock.Lock()
+		cancelled := t.cancelled
+		s.lock.Unlock()
+		if cancelled {
+			return nil, context.Canceled
+		}
+		return t.job()
+	}).OnComplete(func(result Any, err error) {
+		if t.kind == oneShot || err != nil {
+			t.future.complete(result, err)
+			return
+		}
+		s.lock.Lock()
+		if t.cancelled || s.stopping {
+			s.lock.Unlock()
+			// cancelAll() doesn't see a running job, so finish it here.
+			t.future.complete(nil, context.Canceled)
+			return
+		}
+		if t.kind == fixedRate {
+			t.at = t.at.Add(t.period)
+		} else {
+			t.at = s.clock.Now().Add(t.period)
+		}
+		s.push(t)
+		s.lock.Unlock()
+	})
+}
+
+// Remove the job from the schedule. A running job isn't interrupted, but
+// a periodic job doesn't run again. The Future fails with context.Canceled.
+func (t *scheduledTask) Cancel() bool {
+	s := t.scheduler
+	s.lock.Lock()
+	select {
+	case <-t.future.Done():
+		s.lock.Unlock()
+		return false
+	default:
+	}
+	t.cancelled = true
+	if t.index >= 0 {
+		heap.Remove(&s.queue, t.index)
+	}
+	s.lock.Unlock()
+	t.future.complete(nil, context.Canceled)
+	return true
+}
+
+func (s *scheduledExecutor) Shutdown() {
+	s.cancelAll()
+	s.Executor.Shutdown()
+}
+
+func (s *scheduledExecutor) ShutdownNow() []Job {
+	s.cancelAll()
+	return s.Executor.ShutdownNow()
+}
+
+func (s *scheduledExecutor) Stop() {
+	s.cancelAll()
+	s.Executor.Stop()
+}
+
+// Stop scheduling and cancel all jobs waiting in queue.
+func (s *scheduledExecutor) cancelAll() {
+	s.lock.Lock()
+	if s.stopping {
+		s.lock.Unlock()
+		return
+	}
+	s.stopping = true
+	close(s.stopped)
+	tasks := s.queue
+	s.queue = nil
+	for _, t := range tasks {
+		t.index = -1
+	}
+	s.lock.Unlock()
+	for _, t := range tasks {
+		t.future.complete(nil, context.Canceled)
+	}
+}
+
+func (q scheduledQueue) Len() int {
+	return len(q)
+}
+
+func (q scheduledQueue) Less(i, j int) bool {
+	return q[i].at.Before(q[j].at)
+}
+
+func (q scheduledQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduledQueue) Push(x any) {
+	t := x.(*scheduledTask)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+func (q *scheduledQueue) Pop() any {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*q = old[:len(old)-1]
+	return t
+}
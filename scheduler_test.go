@@ -0,0 +1,192 @@
+package goseq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	ch := clock.WaitUntil(start.Add(time.Second))
+	clock.Advance(time.Second / 2)
+	select {
+	case <-ch:
+		t.Error("WaitUntil should not fire before the time.")
+	default:
+	}
+	clock.Advance(time.Second / 2)
+	if now := <-ch; !now.Equal(start.Add(time.Second)) {
+		t.Error("WaitUntil should fire after Advance.", now)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduledExecutor(NewExecutor(1), clock)
+	defer s.Stop()
+	f := s.Schedule(time.Second, func() (Any, error) {
+		return "finished", nil
+	})
+	if _, err := f.ResultTimeout(time.Millisecond); err != context.DeadlineExceeded {
+		t.Error("A scheduled job should not run before the delay.", err)
+	}
+	clock.Advance(time.Second)
+	if res, err := f.Result(); res != "finished" || err != nil {
+		t.Error("A scheduled job should run after the delay.", res, err)
+	}
+}
+
+func TestScheduleAtFixedRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduledExecutor(NewExecutor(1), clock)
+	defer s.Stop()
+	runs := make(chan time.Time, 10)
+	f := s.ScheduleAtFixedRate(time.Second, time.Second, func() (Any, error) {
+		runs <- clock.Now()
+		return nil, nil
+	})
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		if now := <-runs; now.Unix() != int64(i) {
+			t.Error("A periodic job should run every period.", now.Unix())
+		}
+	}
+	if !f.Cancel() {
+		t.Error("Cancel should succeed for a periodic job.")
+	}
+	if _, err := f.Result(); err != context.Canceled {
+		t.Error("A cancelled job should fail with context.Canceled.", err)
+	}
+	if f.Cancel() {
+		t.Error("Cancel should fail for a finished job.")
+	}
+}
+
+func TestScheduleWithFixedDelay(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduledExecutor(NewExecutor(1), clock)
+	defer s.Stop()
+	failed := errors.New("failed")
+	runs := 0
+	f := s.ScheduleWithFixedDelay(0, time.Second, func() (Any, error) {
+		runs++
+		if runs == 2 {
+			return nil, failed
+		}
+		return nil, nil
+	})
+	for {
+		select {
+		case <-f.Done():
+			if _, err := f.Result(); err != failed || runs != 2 {
+				t.Error("A failed run should stop a periodic job.", err, runs)
+			}
+			return
+		default:
+			clock.Advance(time.Second)
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestScheduledExecutorShutdown(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduledExecutor(NewExecutor(1), clock)
+	f := s.Schedule(time.Second, func() (Any, error) {
+		return nil, nil
+	})
+	s.Shutdown()
+	if _, err := f.Result(); err != context.Canceled {
+		t.Error("Shutdown should cancel scheduled jobs.", err)
+	}
+	if _, err := s.Schedule(0, createJobFunc(1, "")).Result(); err != ErrExecutorShutdown {
+		t.Error("Schedule after Shutdown should fail.", err)
+	}
+	if err := s.AwaitTermination(context.Background()); err != nil {
+		t.Error("AwaitTermination should wait for the Executor.", err)
+	}
+}
+
+func TestScheduleInvalidPeriod(t *testing.T) {
+	s := NewScheduledExecutor(NewExecutor(1), NewManualClock(time.Unix(0, 0)))
+	defer s.Stop()
+	job := func() (Any, error) {
+		return nil, nil
+	}
+	if _, err := s.ScheduleAtFixedRate(time.Second, 0, job).Result(); err != ErrInvalidPeriod {
+		t.Error("ScheduleAtFixedRate should reject a period which isn't positive.", err)
+	}
+	if _, err := s.ScheduleWithFixedDelay(time.Second, -time.Second, job).Result(); err != ErrInvalidPeriod {
+		t.Error("ScheduleWithFixedDelay should reject a delay which isn't positive.", err)
+	}
+}
+
+func TestScheduleReusesWait(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduledExecutor(NewExecutor(1), clock)
+	defer s.Stop()
+	job := func() (Any, error) {
+		return nil, nil
+	}
+	s.Schedule(time.Second, job)
+	for i := 0; i < 10; i++ {
+		s.Schedule(2*time.Second, job)
+		time.Sleep(time.Millisecond)
+	}
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	if len(clock.waiters) != 1 {
+		t.Error("Scheduling later jobs should not add waiters to the Clock.", len(clock.waiters))
+	}
+}
+
+func TestShutdownWhilePeriodicJobRuns(t *testing.T) {
+	s := NewScheduledExecutor(NewExecutor(1), NewManualClock(time.Unix(0, 0)))
+	started := make(chan bool)
+	release := make(chan bool)
+	f := s.ScheduleAtFixedRate(0, time.Second, func() (Any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	s.Shutdown()
+	close(release)
+	s.AwaitTermination(context.Background())
+	if _, err := f.ResultTimeout(time.Second); err != context.Canceled {
+		t.Error("Shutdown should finish a periodic job which is running.", err)
+	}
+}
+
+func TestCancelAfterDequeued(t *testing.T) {
+	ex := NewExecutor(1)
+	s := NewScheduledExecutor(ex, NewManualClock(time.Unix(0, 0)))
+	defer s.Stop()
+	release := blockWorker(ex)
+	called := false
+	f := s.Schedule(0, func() (Any, error) {
+		called = true
+		return nil, nil
+	})
+	scheduler := s.(*scheduledExecutor)
+	for {
+		scheduler.lock.Lock()
+		queued := len(scheduler.queue)
+		scheduler.lock.Unlock()
+		if queued == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !f.Cancel() {
+		t.Error("Cancel should succeed before the job starts.")
+	}
+	close(release)
+	ex.Stop()
+	if called {
+		t.Error("A cancelled job should not run after it leaves queue.")
+	}
+}
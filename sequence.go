@@ -1,9 +1,14 @@
 package goseq
 
-import "sync/atomic"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
 
 const (
 	initialSequenceValue = -1
+	cacheLineSize        = 64
 )
 
 type SequenceID int64
@@ -12,10 +17,18 @@ type Sequence interface {
 	Get() SequenceID
 	Set(newSequenceID SequenceID)
 	Next() SequenceID
+	WaitFor(ctx context.Context, target SequenceID) (SequenceID, error)
 }
 
+// value is padded on both sides, so it never shares a cache line with other
+// sequences or fields even if they are allocated next to each other.
 type sequence struct {
-	value int64
+	_       cacheLinePad
+	value   int64
+	_       cacheLinePad
+	waiters int32
+	lock    sync.Mutex
+	changed chan struct{}
 }
 
 func NewSequence() Sequence {
@@ -40,20 +53,77 @@ func (seq *sequence) Next() SequenceID {
 	return SequenceID(seq.incrementAndGet())
 }
 
+// Block until the value becomes target or larger and then return the current
+// value. Waiting goroutines sleep until the value is changed, so this doesn't
+// spin. If ctx is done before reaching target, ctx.Err() is returned.
+func (seq *sequence) WaitFor(ctx context.Context, target SequenceID) (SequenceID, error) {
+	for {
+		if current := seq.Get(); current >= target {
+			return current, nil
+		}
+		changed := seq.subscribe()
+		if current := seq.Get(); current >= target {
+			seq.unsubscribe()
+			return current, nil
+		}
+		select {
+		case <-changed:
+			seq.unsubscribe()
+		case <-ctx.Done():
+			seq.unsubscribe()
+			return seq.Get(), ctx.Err()
+		}
+	}
+}
+
+func (seq *sequence) subscribe() <-chan struct{} {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	atomic.AddInt32(&seq.waiters, 1)
+	if seq.changed == nil {
+		seq.changed = make(chan struct{})
+	}
+	return seq.changed
+}
+
+func (seq *sequence) unsubscribe() {
+	atomic.AddInt32(&seq.waiters, -1)
+}
+
+// Wake up all waiting goroutines. This is cheap when nobody is waiting.
+func (seq *sequence) notify() {
+	if atomic.LoadInt32(&seq.waiters) == 0 {
+		return
+	}
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	if seq.changed != nil {
+		close(seq.changed)
+		seq.changed = nil
+	}
+}
+
 func (seq *sequence) get() int64 {
 	return atomic.LoadInt64(&seq.value)
 }
 
 func (seq *sequence) set(v int64) {
 	atomic.StoreInt64(&seq.value, v)
+	seq.notify()
 }
 
 func (seq *sequence) incrementAndGet() int64 {
-	return atomic.AddInt64(&seq.value, 1)
+	v := atomic.AddInt64(&seq.value, 1)
+	seq.notify()
+	return v
 }
 
 func (seq *sequence) compareAndSet(expected int64, v int64) bool {
-	return atomic.CompareAndSwapInt64(&seq.value, expected, v)
+	if atomic.CompareAndSwapInt64(&seq.value, expected, v) {
+		seq.notify()
+		return true
+	}
+	return false
 }
 
 func (seq *sequence) addAndGet(delta int64) int64 {
@@ -1,6 +1,10 @@
 package goseq
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestNewSequence(t *testing.T) {
 	seq := NewSequence()
@@ -98,3 +102,39 @@ func TestAddAndGet(t *testing.T) {
 		t.Error("addAndSet() should set a new value.")
 	}
 }
+
+func TestWaitFor(t *testing.T) {
+	seq := NewSequence()
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Millisecond)
+			seq.Next()
+		}
+	}()
+	id, err := seq.WaitFor(context.Background(), 2)
+	if err != nil || id != 2 {
+		t.Error("WaitFor should return after reaching target. id:", id, " err:", err)
+	}
+}
+
+func TestWaitForReachedValue(t *testing.T) {
+	seq := NewSequence()
+	seq.Set(5)
+	id, err := seq.WaitFor(context.Background(), 3)
+	if err != nil || id != 5 {
+		t.Error("WaitFor should return a current value when it is already reached.")
+	}
+}
+
+func TestWaitForCanceled(t *testing.T) {
+	seq := newSequence()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	id, err := seq.WaitFor(ctx, 1)
+	if err != context.DeadlineExceeded || id != initialSequenceValue {
+		t.Error("WaitFor should return ctx.Err() when ctx is done. err:", err)
+	}
+	if seq.waiters != 0 {
+		t.Error("WaitFor should remove a waiter after returning.")
+	}
+}
@@ -0,0 +1,151 @@
+package goseq
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// SequenceGroup is a set of Sequences which works as a single barrier.
+// Get() returns the minimum SequenceID of the members, or the maximum
+// SequenceID when there is no member. Members can be added and removed while
+// other goroutines call Get() or WaitFor(). Members are expected to move
+// forward only, except while no goroutine waits for the group.
+type SequenceGroup interface {
+	Add(seq Sequence)
+	Remove(seq Sequence) bool
+	Size() int
+	Get() SequenceID
+	WaitFor(ctx context.Context, target SequenceID) (SequenceID, error)
+}
+
+// members is replaced on Add() and Remove() instead of being modified, so
+// readers don't need the lock.
+type sequenceGroup struct {
+	lock    sync.Mutex
+	members atomic.Pointer[membership]
+}
+
+// changed is closed when the membership is replaced, so WaitFor() stops
+// waiting for a removed member. cached is the minimum found last time. It
+// belongs to the membership, so Get() which read old members cannot
+// overwrite the minimum of new members.
+type membership struct {
+	sequences []Sequence
+	changed   chan struct{}
+	_         cacheLinePad
+	cached    int64
+	_         cacheLinePad
+}
+
+func NewSequenceGroup(seqs ...Sequence) SequenceGroup {
+	return newSequenceGroup(seqs...)
+}
+
+func newSequenceGroup(seqs ...Sequence) (group *sequenceGroup) {
+	group = new(sequenceGroup)
+	group.members.Store(newMembership(append([]Sequence(nil), seqs...)))
+	return
+}
+
+func newMembership(sequences []Sequence) *membership {
+	members := &membership{sequences: sequences, changed: make(chan struct{})}
+	members.minimum()
+	return members
+}
+
+// Add seq as a member.
+func (group *sequenceGroup) Add(seq Sequence) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	current := group.members.Load().sequences
+	sequences := make([]Sequence, len(current), len(current)+1)
+	copy(sequences, current)
+	group.replace(append(sequences, seq))
+}
+
+// Remove seq from members. This returns false when seq is not a member.
+func (group *sequenceGroup) Remove(seq Sequence) bool {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	current := group.members.Load().sequences
+	for i, member := range current {
+		if member == seq {
+			sequences := make([]Sequence, 0, len(current)-1)
+			sequences = append(sequences, current[:i]...)
+			group.replace(append(sequences, current[i+1:]...))
+			return true
+		}
+	}
+	return false
+}
+
+// Store new members and wake goroutines in WaitFor(). This must be called
+// with the lock.
+func (group *sequenceGroup) replace(sequences []Sequence) {
+	previous := group.members.Load()
+	group.members.Store(newMembership(sequences))
+	close(previous.changed)
+}
+
+func (group *sequenceGroup) Size() int {
+	return len(group.members.Load().sequences)
+}
+
+// Return the minimum SequenceID of members and keep it for WaitFor().
+func (group *sequenceGroup) Get() SequenceID {
+	return group.members.Load().minimum()
+}
+
+// Members only move forward, so a minimum stored by a slower caller is
+// still a lower bound of them.
+func (members *membership) minimum() SequenceID {
+	minimum := SequenceID(math.MaxInt64)
+	for _, member := range members.sequences {
+		if n := member.Get(); n < minimum {
+			minimum = n
+		}
+	}
+	atomic.StoreInt64(&members.cached, int64(minimum))
+	return minimum
+}
+
+// Block until all members become target or larger and then return the
+// minimum SequenceID. This returns without reading members when the minimum
+// found last time already reaches target. When members are added or removed
+// while waiting, this checks the new members again.
+func (group *sequenceGroup) WaitFor(ctx context.Context, target SequenceID) (SequenceID, error) {
+	if cached := SequenceID(atomic.LoadInt64(&group.members.Load().cached)); cached >= target {
+		return cached, nil
+	}
+	for {
+		members := group.members.Load()
+		if minimum := members.minimum(); minimum >= target {
+			return minimum, nil
+		}
+		if err := members.waitFor(ctx, target); err != nil {
+			return group.Get(), err
+		}
+	}
+}
+
+// Wait for each member until target. This returns nil without reaching
+// target when the membership is replaced.
+func (members *membership) waitFor(ctx context.Context, target SequenceID) error {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-members.changed:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+	for _, member := range members.sequences {
+		if _, err := member.WaitFor(waitCtx, target); err != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package goseq
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewSequenceGroup(t *testing.T) {
+	group := NewSequenceGroup()
+	if group.Size() != 0 || group.Get() != math.MaxInt64 {
+		t.Error("An empty SequenceGroup should return the maximum SequenceID.", group.Get())
+	}
+}
+
+func TestSequenceGroupGet(t *testing.T) {
+	seq1 := NewSequence()
+	seq2 := NewSequence()
+	seq1.Set(3)
+	seq2.Set(5)
+	group := NewSequenceGroup(seq1, seq2)
+	if group.Get() != 3 {
+		t.Error("Get() should return the minimum of members.", group.Get())
+	}
+	seq1.Set(7)
+	if group.Get() != 5 {
+		t.Error("Get() should follow members.", group.Get())
+	}
+}
+
+func TestSequenceGroupAddAndRemove(t *testing.T) {
+	seq1 := NewSequence()
+	seq2 := NewSequence()
+	seq1.Set(3)
+	seq2.Set(5)
+	group := NewSequenceGroup(seq2)
+	group.Add(seq1)
+	if group.Size() != 2 || group.Get() != 3 {
+		t.Error("Add() should add a member.", group.Size(), group.Get())
+	}
+	if !group.Remove(seq1) || group.Size() != 1 || group.Get() != 5 {
+		t.Error("Remove() should remove a member.", group.Size(), group.Get())
+	}
+	if group.Remove(seq1) {
+		t.Error("Remove() should return false for a sequence which isn't a member.")
+	}
+}
+
+func TestSequenceGroupWaitFor(t *testing.T) {
+	seq1 := NewSequence()
+	seq2 := NewSequence()
+	group := NewSequenceGroup(seq1, seq2)
+	go func() {
+		seq1.Set(2)
+		time.Sleep(time.Millisecond)
+		seq2.Set(3)
+	}()
+	minimum, err := group.WaitFor(context.Background(), 2)
+	if err != nil || minimum != 2 {
+		t.Error("WaitFor should wait for all members.", minimum, err)
+	}
+}
+
+func TestSequenceGroupWaitForCanceled(t *testing.T) {
+	seq := NewSequence()
+	group := NewSequenceGroup(seq)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := group.WaitFor(ctx, 1); err != context.DeadlineExceeded {
+		t.Error("WaitFor should return an error of ctx.", err)
+	}
+}
+
+func TestSequenceGroupWaitForRemoved(t *testing.T) {
+	stalled := NewSequence()
+	seq := NewSequence()
+	group := NewSequenceGroup(stalled, seq)
+	result := make(chan SequenceID)
+	go func() {
+		minimum, _ := group.WaitFor(context.Background(), 1)
+		result <- minimum
+	}()
+	seq.Set(1)
+	time.Sleep(time.Millisecond)
+	group.Remove(stalled)
+	select {
+	case minimum := <-result:
+		if minimum != 1 {
+			t.Error("WaitFor should return the minimum of remaining members.", minimum)
+		}
+	case <-time.After(time.Second):
+		t.Error("WaitFor should stop waiting for a removed member.")
+	}
+}
+
+func TestSequenceGroupStaleGet(t *testing.T) {
+	seq := NewSequence()
+	seq.Set(5)
+	group := newSequenceGroup(seq)
+	stale := group.members.Load()
+	group.Add(NewSequence())
+	// Get() which read the members before Add() finishes after it.
+	stale.minimum()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if minimum, err := group.WaitFor(ctx, 5); err != context.DeadlineExceeded {
+		t.Error("WaitFor should wait for a new member after a stale Get().", minimum, err)
+	}
+}
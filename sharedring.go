@@ -0,0 +1,214 @@
+//go:build linux
+
+package goseq
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// The first cache line of a shared ring file has a header of magic, version,
+// size, slotSize and consumers. Each cursor has its own cache line after
+// that and then slots follow.
+const (
+	sharedRingMagic        = 0x474f5345515249 // "GOSEQRI"
+	sharedRingVersion      = 1
+	sharedRingCursorOffset = cacheLineSize
+)
+
+// ErrRingMismatch is returned when an existing shared ring file was created
+// with different parameters.
+var ErrRingMismatch = errors.New("goseq: shared ring parameters mismatch")
+
+// SharedRing is a ring buffer in a memory mapped file. A producer process
+// and consumer processes open the same file and then exchange slots.
+// A producer calls Next() to get a free slot, writes the slot and then
+// calls Publish(). Each consumer calls Consume() with its own number.
+// Like TaskManager, the producer waits until all consumers finish a slot
+// before reusing it. Only one producer is supported.
+type SharedRing interface {
+	Size() int
+	SlotSize() int
+	Cursor() Sequence
+	Consumer(consumer int) Sequence
+	Slot(id SequenceID) []byte
+	Next(ctx context.Context) (SequenceID, error)
+	Publish(id SequenceID)
+	Consume(ctx context.Context, consumer int, handler func(id SequenceID, slot []byte) error) error
+	Close() error
+}
+
+type sharedRing struct {
+	file        *os.File
+	data        []byte
+	size        int
+	slotSize    int
+	indexMask   SequenceID
+	slotsOffset int
+	cursor      *mmapSequence
+	consumers   []*mmapSequence
+}
+
+// Open a SharedRing at path. When the file doesn't exist, it is created with
+// size slots of slotSize bytes and cursors for 'consumers' consumers.
+// size is required to set 2^x like TaskManager.
+func OpenSharedRing(path string, size, slotSize, consumers int) (SharedRing, error) {
+	return openSharedRing(path, size, slotSize, consumers)
+}
+
+func openSharedRing(path string, size, slotSize, consumers int) (*sharedRing, error) {
+	ring := &sharedRing{size: size, slotSize: slotSize, indexMask: SequenceID(size - 1)}
+	ring.slotsOffset = sharedRingCursorOffset + cacheLineSize*(consumers+1)
+	fileSize := ring.slotsOffset + size*slotSize
+	file, err := openOrCreateFile(path, func(file *os.File) error {
+		return initSharedRing(file, fileSize, size, slotSize, consumers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != int64(fileSize) {
+		file.Close()
+		if err == nil {
+			err = ErrRingMismatch
+		}
+		return nil, err
+	}
+	if ring.data, err = mapFile(file, fileSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	ring.file = file
+	header := []int64{sharedRingMagic, sharedRingVersion, int64(size), int64(slotSize), int64(consumers)}
+	for i, v := range header {
+		if atomic.LoadInt64(int64At(ring.data, i*8)) != v {
+			ring.Close()
+			return nil, ErrRingMismatch
+		}
+	}
+	ring.cursor = newMmapSequence(int64At(ring.data, sharedRingCursorOffset))
+	ring.consumers = make([]*mmapSequence, consumers)
+	for i := range ring.consumers {
+		ring.consumers[i] = newMmapSequence(int64At(ring.data, sharedRingCursorOffset+cacheLineSize*(i+1)))
+	}
+	return ring, nil
+}
+
+// Open a file at path. When it doesn't exist, a temporary file is initialized
+// by init and then linked to path, so other processes never see a file
+// which is not initialized.
+func openOrCreateFile(path string, init func(file *os.File) error) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if !errors.Is(err, os.ErrNotExist) {
+		return file, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err = init(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Link(tmp.Name(), path); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+func initSharedRing(file *os.File, fileSize, size, slotSize, consumers int) error {
+	if err := file.Truncate(int64(fileSize)); err != nil {
+		return err
+	}
+	data, err := mapFile(file, fileSize)
+	if err != nil {
+		return err
+	}
+	defer unmapFile(data)
+	for i := 0; i <= consumers; i++ {
+		*int64At(data, sharedRingCursorOffset+cacheLineSize*i) = initialSequenceValue
+	}
+	header := []int64{sharedRingMagic, sharedRingVersion, int64(size), int64(slotSize), int64(consumers)}
+	for i, v := range header {
+		*int64At(data, i*8) = v
+	}
+	return syncMapping(data)
+}
+
+func (ring *sharedRing) Size() int {
+	return ring.size
+}
+
+func (ring *sharedRing) SlotSize() int {
+	return ring.slotSize
+}
+
+// Return the last published SequenceID.
+func (ring *sharedRing) Cursor() Sequence {
+	return ring.cursor
+}
+
+// Return the last SequenceID finished by consumer.
+func (ring *sharedRing) Consumer(consumer int) Sequence {
+	return ring.consumers[consumer]
+}
+
+// Return a slot for id. The slot is shared with other processes.
+func (ring *sharedRing) Slot(id SequenceID) []byte {
+	offset := ring.slotsOffset + int(id&ring.indexMask)*ring.slotSize
+	return ring.data[offset : offset+ring.slotSize : offset+ring.slotSize]
+}
+
+// Return a next SequenceID whose slot is free. This blocks until all
+// consumers finish the previous SequenceID which used the slot.
+func (ring *sharedRing) Next(ctx context.Context) (SequenceID, error) {
+	next := ring.cursor.Get() + 1
+	wrapPoint := next - SequenceID(ring.size)
+	for _, consumer := range ring.consumers {
+		if _, err := consumer.WaitFor(ctx, wrapPoint); err != nil {
+			return initialSequenceValue, err
+		}
+	}
+	return next, nil
+}
+
+// Make id and its slot visible to consumers.
+func (ring *sharedRing) Publish(id SequenceID) {
+	ring.cursor.Set(id)
+}
+
+// Call handler for each published SequenceID after the last one finished by
+// consumer until ctx is done or handler returns an error.
+func (ring *sharedRing) Consume(ctx context.Context, consumer int, handler func(id SequenceID, slot []byte) error) error {
+	seq := ring.consumers[consumer]
+	for {
+		next := seq.Get() + 1
+		available, err := ring.cursor.WaitFor(ctx, next)
+		if err != nil {
+			return err
+		}
+		for id := next; id <= available; id++ {
+			if err := handler(id, ring.Slot(id)); err != nil {
+				seq.Set(id - 1)
+				return err
+			}
+		}
+		seq.Set(available)
+	}
+}
+
+func (ring *sharedRing) Close() error {
+	err := unmapFile(ring.data)
+	if closeErr := ring.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package goseq
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const sharedRingPathEnv = "GOSEQ_TEST_SHARED_RING"
+
+func TestOpenSharedRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	ring, err := OpenSharedRing(path, 8, 16, 2)
+	if err != nil {
+		t.Fatal("OpenSharedRing failed.", err)
+	}
+	defer ring.Close()
+	if ring.Size() != 8 || ring.SlotSize() != 16 {
+		t.Error("SharedRing should have configured size.")
+	}
+	if ring.Cursor().Get() != initialSequenceValue || ring.Consumer(1).Get() != initialSequenceValue {
+		t.Error("Cursors should be initialized.")
+	}
+	if _, err := OpenSharedRing(path, 16, 16, 2); err != ErrRingMismatch {
+		t.Error("OpenSharedRing should reject different parameters. err:", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Error("OpenSharedRing should not leave temporary files.")
+	}
+}
+
+func TestSharedRingGating(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	producer, _ := OpenSharedRing(path, 2, 8, 1)
+	defer producer.Close()
+	consumer, _ := OpenSharedRing(path, 2, 8, 1)
+	defer consumer.Close()
+
+	for i := 0; i < 2; i++ {
+		id, _ := producer.Next(context.Background())
+		producer.Publish(id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := producer.Next(ctx); err != context.DeadlineExceeded {
+		t.Error("Next should wait until a consumer finishes a slot. err:", err)
+	}
+	consumer.Consumer(0).Set(0)
+	if id, err := producer.Next(context.Background()); err != nil || id != 2 {
+		t.Error("Next should return a free slot.", id, err)
+	}
+}
+
+func TestSharedRingConsume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	producer, _ := OpenSharedRing(path, 4, 8, 1)
+	defer producer.Close()
+	consumer, _ := OpenSharedRing(path, 4, 8, 1)
+	defer consumer.Close()
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			id, _ := producer.Next(context.Background())
+			binary.LittleEndian.PutUint64(producer.Slot(id), uint64(id*10))
+			producer.Publish(id)
+		}
+	}()
+	checkConsume(t, consumer, 100)
+}
+
+func checkConsume(t *testing.T, ring SharedRing, count int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := 0
+	ring.Consume(ctx, 0, func(id SequenceID, slot []byte) error {
+		if binary.LittleEndian.Uint64(slot) != uint64(id*10) {
+			t.Error("Consume should receive a published slot. id:", id)
+		}
+		received++
+		if received == count {
+			cancel()
+		}
+		return nil
+	})
+	if received != count || ring.Consumer(0).Get() != SequenceID(count-1) {
+		t.Error("Consume should receive all ids. received:", received)
+	}
+}
+
+func TestSharedRingAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring")
+	consumer, err := OpenSharedRing(path, 4, 8, 1)
+	if err != nil {
+		t.Fatal("OpenSharedRing failed.", err)
+	}
+	defer consumer.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=TestSharedRingProducerProcess")
+	cmd.Env = append(os.Environ(), sharedRingPathEnv+"="+path)
+	if err := cmd.Start(); err != nil {
+		t.Fatal("Starting a producer process failed.", err)
+	}
+	checkConsume(t, consumer, 100)
+	if err := cmd.Wait(); err != nil {
+		t.Error("A producer process failed.", err)
+	}
+}
+
+// This runs in a child process started by TestSharedRingAcrossProcesses.
+func TestSharedRingProducerProcess(t *testing.T) {
+	path := os.Getenv(sharedRingPathEnv)
+	if path == "" {
+		t.Skip("This is a helper process.")
+	}
+	producer, err := OpenSharedRing(path, 4, 8, 1)
+	if err != nil {
+		t.Fatal("OpenSharedRing failed.", err)
+	}
+	defer producer.Close()
+	for i := 0; i < 100; i++ {
+		id, _ := producer.Next(context.Background())
+		binary.LittleEndian.PutUint64(producer.Slot(id), uint64(id*10))
+		producer.Publish(id)
+	}
+}
@@ -0,0 +1,145 @@
+package goseq
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+)
+
+const spillHeaderSize = 4
+
+// ErrSpillFull is returned by TaskManager.Offer() when the ring buffer and
+// the spill queue are both full.
+var ErrSpillFull = errors.New("goseq: spill queue is full")
+
+// ErrNoOverflow is returned by TaskManager.Offer() before SetOverflow().
+var ErrNoOverflow = errors.New("goseq: no overflow")
+
+// spillQueue is a bounded FIFO queue of payloads in a file. The file is used
+// as a ring buffer of limit bytes, so it never grows larger than limit, and
+// it is truncated whenever the queue becomes empty.
+// readOffset and writeOffset only increase and are wrapped by limit to get
+// positions in the file.
+type spillQueue struct {
+	file        *os.File
+	limit       int64
+	readOffset  int64
+	writeOffset int64
+	header      [spillHeaderSize]byte
+}
+
+// Create a spill queue at path. Entries left by a previous process are
+// discarded. limit is the maximum bytes kept in the file.
+func openSpillQueue(path string, limit int64) (*spillQueue, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &spillQueue{file: file, limit: limit}, nil
+}
+
+func (queue *spillQueue) empty() bool {
+	return queue.readOffset == queue.writeOffset
+}
+
+func (queue *spillQueue) push(data []byte) error {
+	size := int64(spillHeaderSize + len(data))
+	if queue.writeOffset-queue.readOffset+size > queue.limit {
+		return ErrSpillFull
+	}
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[spillHeaderSize:], data)
+	if err := queue.writeAt(buf, queue.writeOffset); err != nil {
+		return err
+	}
+	queue.writeOffset += size
+	return nil
+}
+
+// Read the first entry without removing it.
+func (queue *spillQueue) peek() ([]byte, error) {
+	if err := queue.readAt(queue.header[:], queue.readOffset); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(queue.header[:]))
+	if err := queue.readAt(data, queue.readOffset+spillHeaderSize); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Remove the first entry whose payload is data.
+func (queue *spillQueue) pop(data []byte) error {
+	queue.readOffset += int64(spillHeaderSize + len(data))
+	if queue.empty() {
+		queue.readOffset = 0
+		queue.writeOffset = 0
+		return queue.file.Truncate(0)
+	}
+	return nil
+}
+
+// Write buf at offset and wrap to the head of the file at limit.
+func (queue *spillQueue) writeAt(buf []byte, offset int64) error {
+	position := offset % queue.limit
+	first := min(int64(len(buf)), queue.limit-position)
+	if _, err := queue.file.WriteAt(buf[:first], position); err != nil {
+		return err
+	}
+	if first < int64(len(buf)) {
+		_, err := queue.file.WriteAt(buf[first:], 0)
+		return err
+	}
+	return nil
+}
+
+// Read buf from offset and wrap to the head of the file at limit.
+func (queue *spillQueue) readAt(buf []byte, offset int64) error {
+	position := offset % queue.limit
+	first := min(int64(len(buf)), queue.limit-position)
+	if _, err := queue.file.ReadAt(buf[:first], position); err != nil {
+		return err
+	}
+	if first < int64(len(buf)) {
+		_, err := queue.file.ReadAt(buf[first:], 0)
+		return err
+	}
+	return nil
+}
+
+func (queue *spillQueue) close() error {
+	path := queue.file.Name()
+	err := queue.file.Close()
+	if removeErr := os.Remove(path); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// overflow moves payloads from a spill queue to a TaskManager when slots
+// become free.
+type overflow struct {
+	lock        sync.Mutex
+	queue       *spillQueue
+	codec       SlotCodec
+	signal      chan bool
+	stopChannel chan bool
+	waitingStop sync.WaitGroup
+}
+
+func newOverflow(queue *spillQueue, codec SlotCodec) (of *overflow) {
+	of = new(overflow)
+	of.queue = queue
+	of.codec = codec
+	of.signal = make(chan bool, 1)
+	return
+}
+
+func (of *overflow) notify() {
+	select {
+	case of.signal <- true:
+	default:
+	}
+}
@@ -0,0 +1,130 @@
+package goseq
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSpillQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill")
+	queue, err := openSpillQueue(path, 20)
+	if err != nil {
+		t.Fatal("openSpillQueue failed.", err)
+	}
+	if !queue.empty() {
+		t.Error("A new queue should be empty.")
+	}
+	queue.push([]byte("first"))
+	queue.push([]byte("second"))
+	if err := queue.push([]byte("third")); err != ErrSpillFull {
+		t.Error("push should return ErrSpillFull over limit. err:", err)
+	}
+	data, _ := queue.peek()
+	if string(data) != "first" {
+		t.Error("peek should return the first entry.", string(data))
+	}
+	queue.pop(data)
+	data, _ = queue.peek()
+	queue.pop(data)
+	if string(data) != "second" || !queue.empty() {
+		t.Error("pop should remove entries in order.")
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Error("An empty queue should truncate a file.")
+	}
+	queue.close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("close should remove a file.")
+	}
+}
+
+func TestSpillQueueWraps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill")
+	queue, err := openSpillQueue(path, 100)
+	if err != nil {
+		t.Fatal("openSpillQueue failed.", err)
+	}
+	defer queue.close()
+	queue.push([]byte("head"))
+	for i := 0; i < 1000; i++ {
+		if err := queue.push([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal("push failed.", i, err)
+		}
+		data, _ := queue.peek()
+		queue.pop(data)
+		if i > 0 && string(data) != strconv.Itoa(i-1) {
+			t.Fatal("pop should keep the order across wraps.", i, string(data))
+		}
+	}
+	if queue.empty() {
+		t.Error("The queue should keep an entry.")
+	}
+	if info, _ := os.Stat(path); info.Size() > 100 {
+		t.Error("A file should not grow over limit.", info.Size())
+	}
+}
+
+func TestOffer(t *testing.T) {
+	var m sync.Mutex
+	processed := make([]string, 0, 20)
+	release := make(chan bool)
+	codec := &testSlotCodec{slots: make([]string, 4)}
+	tm := NewTaskManager(4)
+	tm.AddHandler(func(id SequenceID, index int) {
+		<-release
+		m.Lock()
+		defer m.Unlock()
+		processed = append(processed, codec.slots[index])
+	})
+	if err := tm.SetOverflow(filepath.Join(t.TempDir(), "spill"), 1024, codec); err != nil {
+		t.Fatal("SetOverflow failed.", err)
+	}
+	tm.Start()
+	for i := 0; i < 20; i++ {
+		if err := tm.Offer([]byte(strconv.Itoa(i))); err != nil {
+			t.Error("Offer should not fail.", err)
+		}
+	}
+	close(release)
+	tm.Stop()
+
+	if len(processed) != 20 {
+		t.Fatal("All offered payloads should be processed. len:", len(processed))
+	}
+	for i, value := range processed {
+		if value != strconv.Itoa(i) {
+			t.Error("Payloads should be processed in order.", processed)
+			break
+		}
+	}
+}
+
+func TestOfferSpillFull(t *testing.T) {
+	release := make(chan bool)
+	codec := &testSlotCodec{slots: make([]string, 2)}
+	tm := NewTaskManager(2)
+	tm.AddHandler(func(id SequenceID, index int) {
+		<-release
+	})
+	tm.SetOverflow(filepath.Join(t.TempDir(), "spill"), 10, codec)
+	tm.Start()
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = tm.Offer([]byte("data"))
+	}
+	close(release)
+	tm.Stop()
+	if err != ErrSpillFull {
+		t.Error("Offer should return ErrSpillFull when the queue is full. err:", err)
+	}
+}
+
+func TestOfferWithoutOverflow(t *testing.T) {
+	tm := NewTaskManager(2)
+	if err := tm.Offer([]byte("data")); err != ErrNoOverflow {
+		t.Error("Offer without SetOverflow should fail.", err)
+	}
+}
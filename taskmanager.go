@@ -1,6 +1,8 @@
 package goseq
 
 import (
+	"context"
+	"io"
 	"time"
 )
 
@@ -13,6 +15,24 @@ const (
 // 'id' value. index is between 0 and (size -1).
 type TaskHandler func(id SequenceID, index int)
 
+// This is a TaskHandler which receives a context. ctx is cancelled by
+// TaskManager.StopNow() or when a timeout of the HandlerGroup is expired.
+// TaskManager.Stop() doesn't cancel ctx until all put SequenceIDs are handled.
+// ctx also has values of a context given to TaskManager.PutContext().
+// A returned error is passed to an ErrorHandler of the HandlerGroup.
+type ContextTaskHandler func(ctx context.Context, id SequenceID, index int) error
+
+// This handler receives continuous SequenceIDs from 'from' to 'to' at once.
+// SequenceIDs which are available since the last call are passed together.
+type BatchHandler func(ctx context.Context, from, to SequenceID) error
+
+func (handler TaskHandler) withContext() ContextTaskHandler {
+	return func(ctx context.Context, id SequenceID, index int) error {
+		handler(id, index)
+		return nil
+	}
+}
+
 // Manage several TaskHandlers.
 // Create a new instance using NewTaskManager() and then
 // add TaskHandlers. And then, call Start() method to setup
@@ -21,10 +41,23 @@ type TaskHandler func(id SequenceID, index int)
 // Current version can support a single thread to call Put method.
 type TaskManager interface {
 	Put(initHandler TaskHandler) SequenceID
+	PutContext(ctx context.Context, initHandler TaskHandler) SequenceID
 	AddHandler(handler TaskHandler, handlers ...TaskHandler) HandlerGroup
 	AddHandlers(handlers []TaskHandler) HandlerGroup
+	AddContextHandler(handler ContextTaskHandler, handlers ...ContextTaskHandler) HandlerGroup
+	AddBatchHandler(handler BatchHandler) HandlerGroup
+	AddCommitter(w io.Writer, codec SlotCodec) HandlerGroup
+	MonitorLag(high, low float64, interval time.Duration, onHigh, onLow LagHandler)
+	Watch(timeout time.Duration, handler StallHandler)
+	SetCheckpointer(checkpointer Checkpointer, interval time.Duration, onError func(err error))
+	SetJournal(journal Journal, codec SlotCodec)
+	SetErrorHandler(handler ErrorHandler)
+	Replay(from SequenceID) error
+	SetOverflow(path string, limit int64, codec SlotCodec) error
+	Offer(data []byte) error
 	Start()
 	Stop()
+	StopNow()
 }
 
 type sequenceIDToIndexFunc func(id SequenceID) (index int)
@@ -32,10 +65,20 @@ type sequenceIDToIndexFunc func(id SequenceID) (index int)
 type taskManager struct {
 	seqToIndexFunc      sequenceIDToIndexFunc
 	handlerGroups       []HandlerGroup
+	contexts            []context.Context
+	journal             Journal
+	codec               SlotCodec
+	replaying           bool
+	errorHandler        ErrorHandler
+	overflow            *overflow
+	monitors            []monitor
+	gating              *sequenceGroup
 	size                SequenceID
 	indexMask           SequenceID
-	currentID           SequenceID
+	cursor              *sequence
+	_                   cacheLinePad
 	cachedMinSequenceID SequenceID
+	_                   cacheLinePad
 }
 
 // Create a new TaskManager instance.
@@ -44,13 +87,34 @@ func NewTaskManager(size int) TaskManager {
 	return newTaskManager(size)
 }
 
+// Create a new TaskManager instance which resumes from a Checkpoint loaded
+// by checkpointer. The first Put() returns the next SequenceID of the saved
+// Cursor and all HandlerGroups start from the Cursor. SequenceIDs after
+// Checkpoint.Processed() until Cursor may not be finished before saving.
+// Put() cannot pass them again because it always returns a new SequenceID,
+// so call Replay(checkpoint.Processed()+1) after Start() with a Journal set
+// by SetJournal() to process them. When no Checkpoint is saved yet, this is
+// the same as NewTaskManager().
+func NewTaskManagerFromCheckpoint(size int, checkpointer Checkpointer) (TaskManager, error) {
+	checkpoint, err := loadCheckpoint(checkpointer)
+	if err != nil {
+		return nil, err
+	}
+	tm := newTaskManager(size)
+	tm.cursor.Set(checkpoint.Cursor)
+	return tm, nil
+}
+
 func newTaskManager(size int) (tm *taskManager) {
 	tm = new(taskManager)
-	tm.currentID = initialSequenceValue
+	tm.cursor = newSequence()
 	tm.cachedMinSequenceID = initialSequenceValue
+	tm.gating = newSequenceGroup()
 	tm.size = SequenceID(size)
 	tm.indexMask = SequenceID(size - 1)
 	tm.handlerGroups = make([]HandlerGroup, 0, initialTasksCap)
+	tm.monitors = make([]monitor, 0, initialTasksCap)
+	tm.contexts = make([]context.Context, size)
 	tm.seqToIndexFunc = func(id SequenceID) int {
 		return int(tm.indexMask & id)
 	}
@@ -63,50 +127,63 @@ func newTaskManager(size int) (tm *taskManager) {
 // usage to call this method. If 'index' is still used, then this method
 // block the call until 'index' becomes available state.
 func (tm *taskManager) Put(initHandler TaskHandler) SequenceID {
-	current := tm.currentID
+	return tm.putContext(nil, initHandler)
+}
+
+// This method is the same as Put() and ctx's values are passed to
+// ContextTaskHandlers of all HandlerGroups for the new SequenceID.
+func (tm *taskManager) PutContext(ctx context.Context, initHandler TaskHandler) SequenceID {
+	return tm.putContext(ctx, initHandler)
+}
+
+func (tm *taskManager) putContext(ctx context.Context, initHandler TaskHandler) SequenceID {
+	current := tm.cursor.Get()
 	nextID := current + 1
 	wrapPoint := nextID - tm.size
 	cachedMinSequenceID := tm.cachedMinSequenceID
 
 	if wrapPoint > cachedMinSequenceID || cachedMinSequenceID > current {
-		var minSequenceID SequenceID
-		for {
-			minSequenceID := tm.getMinimumLastProcessedID(current)
-			if wrapPoint > minSequenceID {
-				time.Sleep(1)
-			} else {
-				break
-			}
-		}
+		minSequenceID, _ := tm.gating.WaitFor(context.Background(), wrapPoint)
 		tm.cachedMinSequenceID = minSequenceID
 	}
-	tm.currentID = nextID
-
-	defer tm.put(nextID)
 
+	index := tm.seqToIndexFunc(nextID)
+	tm.contexts[index] = ctx
 	if initHandler != nil {
-		initHandler(nextID, tm.seqToIndexFunc(nextID))
+		initHandler(nextID, index)
 	}
+	if tm.journal != nil && !tm.replaying {
+		tm.appendJournal(nextID, index)
+	}
+	// Publish nextID. Handlers of first groups wait for the cursor.
+	tm.cursor.Set(nextID)
 	return nextID
 }
 
-// This can support a single thread operation only because
-// multi thread call may put different order.
-func (tm *taskManager) put(id SequenceID) {
-	for _, group := range tm.handlerGroups {
-		group.process(id)
+func (tm *taskManager) appendJournal(id SequenceID, index int) {
+	data, err := tm.codec.Marshal(id, index)
+	if err == nil {
+		err = tm.journal.Append(id, data)
+	}
+	if err != nil {
+		tm.handleError(id, index, err)
+	}
+}
+
+func (tm *taskManager) handleError(id SequenceID, index int, err error) {
+	if tm.errorHandler != nil {
+		tm.errorHandler(id, index, err)
 	}
 }
 
 func (tm *taskManager) AddHandler(handler TaskHandler, handlers ...TaskHandler) HandlerGroup {
-	group := newHandlerGroup(tm.seqToIndexFunc)
+	group := tm.newHandlerGroup()
 	if handler != nil {
 		group.AddHandler(handler)
 	}
 	if len(handlers) > 0 {
 		group.AddHandlers(handlers)
 	}
-	tm.handlerGroups = append(tm.handlerGroups, group)
 	return group
 }
 
@@ -114,29 +191,305 @@ func (tm *taskManager) AddHandlers(handlers []TaskHandler) HandlerGroup {
 	return tm.AddHandler(nil, handlers...)
 }
 
+func (tm *taskManager) AddContextHandler(handler ContextTaskHandler, handlers ...ContextTaskHandler) HandlerGroup {
+	group := tm.newHandlerGroup()
+	group.AddContextHandler(handler, handlers...)
+	return group
+}
+
+func (tm *taskManager) AddBatchHandler(handler BatchHandler) HandlerGroup {
+	group := tm.newHandlerGroup()
+	group.AddBatchHandler(handler)
+	return group
+}
+
+// Add a HandlerGroup which writes payloads of available SequenceIDs to w
+// through codec at once, syncs w once when w has Sync() like *os.File and
+// then finishes the SequenceIDs. So, groups added by Then() receive only
+// committed SequenceIDs. Written entries can be read by ReadCommitLog().
+// When writing fails, the error is passed to the group's ErrorHandler and
+// the same SequenceIDs are written again after a delay, so next groups wait
+// until they are committed. A failed Write() can leave a partial entry in w.
+// When writing still fails after Stop(), next groups stop before the failed
+// SequenceIDs and they are written again after Start().
+func (tm *taskManager) AddCommitter(w io.Writer, codec SlotCodec) HandlerGroup {
+	group := tm.newHandlerGroup()
+	group.addCommitter(w, codec)
+	return group
+}
+
+func (tm *taskManager) newHandlerGroup() *handlerGroup {
+	group := newHandlerGroup(tm.seqToIndexFunc)
+	group.contexts = tm.contexts
+	group.setBarrier(tm.cursor)
+	group.lastProcessedID.Set(tm.cursor.Get())
+	tm.handlerGroups = append(tm.handlerGroups, group)
+	return group
+}
+
+// Watch lag of each HandlerGroup every interval. The lag is the number of
+// SequenceIDs which are put but not processed by a group yet. onHigh is called
+// when the lag reaches high * size and then onLow is called when the lag falls
+// to low * size or less. Either handler can be nil. Call this before Start().
+func (tm *taskManager) MonitorLag(high, low float64, interval time.Duration, onHigh, onLow LagHandler) {
+	monitor := newLagMonitor(tm.cursor, interval, onHigh, onLow)
+	monitor.high = SequenceID(high * float64(tm.size))
+	monitor.low = SequenceID(low * float64(tm.size))
+	monitor.groups = tm.allHandlerGroups
+	tm.monitors = append(tm.monitors, monitor)
+}
+
+// Report a HandlerGroup through handler when its LastProcessedID is not
+// updated for timeout while there are put SequenceIDs for the group.
+// Call this before Start().
+func (tm *taskManager) Watch(timeout time.Duration, handler StallHandler) {
+	watchdog := newWatchdog(tm.cursor, timeout, handler)
+	watchdog.groups = tm.allHandlerGroups
+	tm.monitors = append(tm.monitors, watchdog)
+}
+
+// Save a Checkpoint every interval and when Stop() is called. Groups of the
+// Checkpoint are LastProcessedID of terminal HandlerGroups. onError is
+// called when saving fails. Call this before Start().
+func (tm *taskManager) SetCheckpointer(checkpointer Checkpointer, interval time.Duration, onError func(err error)) {
+	monitor := newCheckpointMonitor(checkpointer, tm.cursor, interval, onError)
+	monitor.groups = tm.lastHandlerGroups
+	tm.monitors = append(tm.monitors, monitor)
+}
+
+// Append a payload of each put SequenceID to journal before HandlerGroups
+// receive the SequenceID. A payload is read from a slot through codec after
+// calling initHandler of Put(). The journal is synced when Stop() is called.
+// When a put SequenceID doesn't follow Last() of journal, for example after
+// restarting without Replay(), ErrJournalSequence is passed to ErrorHandler.
+// Call this before Start().
+func (tm *taskManager) SetJournal(journal Journal, codec SlotCodec) {
+	tm.journal = journal
+	tm.codec = codec
+}
+
+// Set an ErrorHandler to receive errors of Put() like a failure to append
+// to a Journal. Errors of HandlerGroups are passed to each group's handler.
+func (tm *taskManager) SetErrorHandler(handler ErrorHandler) {
+	tm.errorHandler = handler
+}
+
+// Put journaled payloads from 'from' again to all HandlerGroups with their
+// original SequenceIDs. This waits for all put SequenceIDs to be finished and
+// then moves the TaskManager to 'from'. So, SequenceIDs which were already
+// finished are processed again. Call this after Start() from the same
+// goroutine as Put().
+func (tm *taskManager) Replay(from SequenceID) error {
+	if tm.journal == nil {
+		return ErrNoJournal
+	}
+	if from > tm.journal.Last()+1 {
+		return ErrJournalSequence
+	}
+	cursor := tm.cursor.Get()
+	for _, group := range tm.lastHandlerGroups() {
+		group.WaitFor(context.Background(), cursor)
+	}
+	// Handlers keep their positions while running, so restart them at 'from'.
+	for _, group := range tm.handlerGroups {
+		group.stopAll()
+	}
+	tm.rewind(from - 1)
+	for _, group := range tm.handlerGroups {
+		group.startAll()
+	}
+	// Replayed payloads are already in the journal.
+	tm.replaying = true
+	defer func() { tm.replaying = false }()
+	return tm.journal.Read(from, func(id SequenceID, data []byte) error {
+		if id != tm.cursor.Get()+1 {
+			return ErrJournalSequence
+		}
+		var err error
+		tm.Put(func(id SequenceID, index int) {
+			err = tm.codec.Unmarshal(id, index, data)
+		})
+		return err
+	})
+}
+
+func (tm *taskManager) rewind(id SequenceID) {
+	tm.cursor.Set(id)
+	tm.cachedMinSequenceID = id
+	for _, group := range tm.allHandlerGroups() {
+		group.rewind(id)
+	}
+	// Sequences of the gating group moved back, so drop its cached minimum.
+	tm.gating.Get()
+}
+
+// Enable Offer() with a spill queue in a file at path. When no slot is free,
+// Offer() appends a payload to the queue until the file becomes limit bytes.
+// Queued payloads are put in order as slots become free. codec is used to
+// write a payload to a slot. The file is removed by Stop(). Call this before
+// Start().
+func (tm *taskManager) SetOverflow(path string, limit int64, codec SlotCodec) error {
+	queue, err := openSpillQueue(path, limit)
+	if err != nil {
+		return err
+	}
+	tm.overflow = newOverflow(queue, codec)
+	return nil
+}
+
+// Put a serialized payload without blocking. When a slot is free and no
+// payload is waiting in the spill queue, data is written to the slot through
+// SlotCodec and then HandlerGroups receive it. Otherwise, data is appended to
+// the spill queue and put later in order. ErrSpillFull is returned when the
+// spill queue is full and ErrNoOverflow is returned before SetOverflow().
+// Don't call Put() while using this method.
+func (tm *taskManager) Offer(data []byte) error {
+	of := tm.overflow
+	if of == nil {
+		return ErrNoOverflow
+	}
+	of.lock.Lock()
+	defer of.lock.Unlock()
+	if of.queue.empty() && tm.hasCapacity() {
+		tm.putData(data, of.codec)
+		return nil
+	}
+	if err := of.queue.push(data); err != nil {
+		return err
+	}
+	of.notify()
+	return nil
+}
+
+// Put payloads in the spill queue until stopped. Only this goroutine puts
+// while the queue is not empty, so waiting for a free slot outside of the
+// lock keeps the order and Offer() never waits for the slot.
+func (tm *taskManager) drainOverflow() {
+	of := tm.overflow
+	defer of.waitingStop.Done()
+	for {
+		select {
+		case <-of.signal:
+		case <-of.stopChannel:
+			tm.drainSpillQueue()
+			return
+		}
+		tm.drainSpillQueue()
+	}
+}
+
+func (tm *taskManager) drainSpillQueue() {
+	of := tm.overflow
+	for {
+		of.lock.Lock()
+		if of.queue.empty() {
+			of.lock.Unlock()
+			return
+		}
+		data, err := of.queue.peek()
+		of.lock.Unlock()
+		if err != nil {
+			next := tm.cursor.Get() + 1
+			tm.handleError(next, tm.seqToIndexFunc(next), err)
+			return
+		}
+		tm.waitCapacity()
+		of.lock.Lock()
+		tm.putData(data, of.codec)
+		err = of.queue.pop(data)
+		of.lock.Unlock()
+		if err != nil {
+			current := tm.cursor.Get()
+			tm.handleError(current, tm.seqToIndexFunc(current), err)
+		}
+	}
+}
+
+func (tm *taskManager) closeOverflow() {
+	if err := tm.overflow.queue.close(); err != nil {
+		current := tm.cursor.Get()
+		tm.handleError(current, tm.seqToIndexFunc(current), err)
+	}
+}
+
+func (tm *taskManager) putData(data []byte, codec SlotCodec) {
+	tm.Put(func(id SequenceID, index int) {
+		if err := codec.Unmarshal(id, index, data); err != nil {
+			tm.handleError(id, index, err)
+		}
+	})
+}
+
+// Return true when Put() can get a next slot without blocking.
+func (tm *taskManager) hasCapacity() bool {
+	return tm.cursor.Get()+1-tm.size <= tm.gating.Get()
+}
+
+// Block until Put() can get a next slot.
+func (tm *taskManager) waitCapacity() {
+	tm.gating.WaitFor(context.Background(), tm.cursor.Get()+1-tm.size)
+}
+
 // Start all configured channels. Don't add new handlers/groups after starting handlers.
 func (tm *taskManager) Start() {
+	for _, group := range tm.lastHandlerGroups() {
+		tm.gating.Add(group.lastProcessedSequence())
+	}
 	for _, group := range tm.handlerGroups {
 		group.startAll()
 	}
+	for _, monitor := range tm.monitors {
+		monitor.start()
+	}
+	if tm.overflow != nil {
+		tm.overflow.stopChannel = make(chan bool)
+		tm.overflow.waitingStop.Add(1)
+		go tm.drainOverflow()
+	}
 }
 
 // Stop all configured channels. This is blocked until finishing all goroutines.
 func (tm *taskManager) Stop() {
+	if tm.overflow != nil {
+		close(tm.overflow.stopChannel)
+		tm.overflow.waitingStop.Wait()
+		tm.closeOverflow()
+	}
 	for _, group := range tm.handlerGroups {
 		group.stopAll()
 	}
+	for _, monitor := range tm.monitors {
+		monitor.stop()
+	}
+	if tm.journal != nil {
+		if err := tm.journal.Sync(); err != nil {
+			tm.handleError(tm.cursor.Get(), tm.seqToIndexFunc(tm.cursor.Get()), err)
+		}
+	}
 }
 
-func (tm *taskManager) getMinimumLastProcessedID(minimum SequenceID) SequenceID {
-	processedID := minimum
-	for _, handlerGroup := range tm.handlerGroups {
-		for _, lastGroup := range handlerGroup.lastHandlerGroups() {
-			n := lastGroup.LastProcessedID()
-			if n < processedID {
-				processedID = n
-			}
-		}
+// The same as Stop() except that contexts of ContextTaskHandlers are
+// cancelled first. Put SequenceIDs are still passed to handlers, so handlers
+// which respect ctx can finish them quickly.
+func (tm *taskManager) StopNow() {
+	for _, group := range tm.handlerGroups {
+		group.cancelAll()
+	}
+	tm.Stop()
+}
+
+func (tm *taskManager) lastHandlerGroups() []HandlerGroup {
+	groups := make([]HandlerGroup, 0, len(tm.handlerGroups))
+	for _, group := range tm.handlerGroups {
+		groups = append(groups, group.lastHandlerGroups()...)
+	}
+	return groups
+}
+
+func (tm *taskManager) allHandlerGroups() []HandlerGroup {
+	groups := make([]HandlerGroup, 0, len(tm.handlerGroups))
+	for _, group := range tm.handlerGroups {
+		groups = append(groups, group.allHandlerGroups()...)
 	}
-	return processedID
+	return groups
 }
@@ -1,6 +1,8 @@
 package goseq
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -148,6 +150,114 @@ func TestWaitingPut(t *testing.T) {
 	}
 }
 
+type testContextKey struct{}
+
+func TestPutContext(t *testing.T) {
+	var m sync.Mutex
+	values := make([]Any, 0, 2)
+	handler := func(ctx context.Context, id SequenceID, index int) error {
+		m.Lock()
+		defer m.Unlock()
+		values = append(values, ctx.Value(testContextKey{}))
+		return nil
+	}
+	tm := NewTaskManager(defaultIndexSize)
+	tm.AddContextHandler(handler).ThenContext(handler)
+	tm.Start()
+	tm.PutContext(context.WithValue(context.Background(), testContextKey{}, "value"), nil)
+	tm.Stop()
+	if len(values) != 2 || values[0] != "value" || values[1] != "value" {
+		t.Error("PutContext should pass values to all groups. values:", values)
+	}
+}
+
+func TestContextHandlerError(t *testing.T) {
+	handlerErr := errors.New("handler error")
+	var receivedID SequenceID = initialSequenceValue
+	var receivedErr error
+	tm := NewTaskManager(defaultIndexSize)
+	group := tm.AddContextHandler(func(ctx context.Context, id SequenceID, index int) error {
+		return handlerErr
+	})
+	group.SetErrorHandler(func(id SequenceID, index int, err error) {
+		receivedID, receivedErr = id, err
+	})
+	tm.Start()
+	tm.Put(nil)
+	tm.Stop()
+	if receivedID != 0 || receivedErr != handlerErr {
+		t.Error("An error of a handler should be passed to ErrorHandler.", receivedID, receivedErr)
+	}
+}
+
+func TestContextHandlerTimeout(t *testing.T) {
+	var m sync.Mutex
+	errs := make([]error, 0, 1)
+	tm := NewTaskManager(defaultIndexSize)
+	group := tm.AddContextHandler(func(ctx context.Context, id SequenceID, index int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	group.SetTimeout(time.Millisecond)
+	group.SetErrorHandler(func(id SequenceID, index int, err error) {
+		m.Lock()
+		defer m.Unlock()
+		errs = append(errs, err)
+	})
+	tm.Start()
+	id := tm.Put(nil)
+	group.WaitFor(context.Background(), id)
+	tm.Stop()
+	m.Lock()
+	defer m.Unlock()
+	if len(errs) != 1 || errs[0] != ErrHandlerTimeout {
+		t.Error("A timeout should cancel ctx and report ErrHandlerTimeout once. errs:", errs)
+	}
+}
+
+func TestContextCancelledByStopNow(t *testing.T) {
+	started := make(chan bool)
+	var handlerErr error
+	tm := NewTaskManager(defaultIndexSize)
+	tm.AddContextHandler(func(ctx context.Context, id SequenceID, index int) error {
+		close(started)
+		<-ctx.Done()
+		handlerErr = ctx.Err()
+		return nil
+	})
+	tm.Start()
+	tm.Put(nil)
+	<-started
+	tm.StopNow()
+	if handlerErr != context.Canceled {
+		t.Error("StopNow should cancel contexts of handlers. err:", handlerErr)
+	}
+}
+
+func TestStopDrainsWithLiveContexts(t *testing.T) {
+	release := make(chan bool)
+	cancelled := 0
+	tm := NewTaskManager(defaultIndexSize)
+	tm.AddContextHandler(func(ctx context.Context, id SequenceID, index int) error {
+		if id == 0 {
+			<-release
+		}
+		if ctx.Err() != nil {
+			cancelled++
+		}
+		return nil
+	})
+	tm.Start()
+	for i := 0; i < 5; i++ {
+		tm.Put(nil)
+	}
+	go close(release)
+	tm.Stop()
+	if cancelled != 0 {
+		t.Error("Stop should not cancel contexts of pending SequenceIDs.", cancelled)
+	}
+}
+
 func BenchmarkHandler(b *testing.B) {
 	f := func(id SequenceID, index int) {
 		index++
@@ -185,3 +295,39 @@ func BenchmarkPut(b *testing.B) {
 		}
 	}
 }
+
+// A producer updates a cursor while a consumer updates its lastProcessedID.
+type unpaddedCursors struct {
+	cursor          int64
+	lastProcessedID int64
+}
+
+type paddedCursors struct {
+	cursor          sequence
+	lastProcessedID sequence
+}
+
+func BenchmarkPaddedCursors(b *testing.B) {
+	cursors := new(paddedCursors)
+	benchmarkUpdateValues(b, []*int64{&cursors.cursor.value, &cursors.lastProcessedID.value})
+}
+
+func BenchmarkUnpaddedCursors(b *testing.B) {
+	cursors := new(unpaddedCursors)
+	benchmarkUpdateValues(b, []*int64{&cursors.cursor, &cursors.lastProcessedID})
+}
+
+// Put b.N SequenceIDs through two groups. Compare the result with
+// 'go test -tags nopadding -bench PutPipeline' to see the effect of padding
+// on the real pipeline.
+func BenchmarkPutPipeline(b *testing.B) {
+	handler := func(id SequenceID, index int) {}
+	tm := NewTaskManager(defaultIndexSize)
+	tm.AddHandler(handler).Then(handler)
+	tm.Start()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tm.Put(nil)
+	}
+	tm.Stop()
+}
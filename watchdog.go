@@ -0,0 +1,164 @@
+package goseq
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minWatchInterval = time.Millisecond
+	stackBufferSize  = 64 * 1024
+)
+
+// HandlerState shows what a handler goroutine of a HandlerGroup is doing.
+// ID is the SequenceID which the handler is running when Running is true.
+// Stack is filled only in a StallReport.
+type HandlerState struct {
+	GoroutineID int64
+	ID          SequenceID
+	Running     bool
+	Stack       string
+}
+
+// StallReport describes a HandlerGroup whose LastProcessedID is not updated
+// for Duration while Cursor is larger than LastProcessedID.
+type StallReport struct {
+	Group           HandlerGroup
+	LastProcessedID SequenceID
+	Cursor          SequenceID
+	Duration        time.Duration
+	Handlers        []HandlerState
+}
+
+// StallHandler is called once for each stall. It is called again only after
+// the stalled HandlerGroup makes progress and then stalls again.
+type StallHandler func(report StallReport)
+
+type watchProgress struct {
+	lastProcessedID SequenceID
+	since           time.Time
+	reported        bool
+}
+
+type watchdog struct {
+	cursor      Sequence
+	groups      func() []HandlerGroup
+	timeout     time.Duration
+	handler     StallHandler
+	progress    map[HandlerGroup]*watchProgress
+	stopChannel chan bool
+	waitingStop sync.WaitGroup
+}
+
+func newWatchdog(cursor Sequence, timeout time.Duration, handler StallHandler) (wd *watchdog) {
+	wd = new(watchdog)
+	wd.cursor = cursor
+	wd.timeout = timeout
+	wd.handler = handler
+	wd.progress = make(map[HandlerGroup]*watchProgress)
+	return
+}
+
+func (wd *watchdog) start() {
+	wd.stopChannel = make(chan bool)
+	wd.waitingStop.Add(1)
+	go wd.run(wd.groups())
+}
+
+func (wd *watchdog) stop() {
+	close(wd.stopChannel)
+	wd.waitingStop.Wait()
+}
+
+func (wd *watchdog) run(groups []HandlerGroup) {
+	defer wd.waitingStop.Done()
+	interval := wd.timeout / 2
+	if interval < minWatchInterval {
+		interval = minWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, group := range groups {
+				wd.check(group, now)
+			}
+		case <-wd.stopChannel:
+			return
+		}
+	}
+}
+
+func (wd *watchdog) check(group HandlerGroup, now time.Time) {
+	lastProcessedID := group.LastProcessedID()
+	progress, ok := wd.progress[group]
+	if !ok || progress.lastProcessedID != lastProcessedID {
+		wd.progress[group] = &watchProgress{lastProcessedID: lastProcessedID, since: now}
+		return
+	}
+	cursor := wd.cursor.Get()
+	if cursor <= lastProcessedID {
+		// An idle group starts waiting when a new SequenceID is put.
+		progress.since = now
+		return
+	}
+	if progress.reported || now.Sub(progress.since) < wd.timeout {
+		return
+	}
+	progress.reported = true
+	wd.handler(StallReport{
+		Group:           group,
+		LastProcessedID: lastProcessedID,
+		Cursor:          cursor,
+		Duration:        now.Sub(progress.since),
+		Handlers:        withStacks(group.handlerStates()),
+	})
+}
+
+// Fill stacks of handler goroutines from a dump of all goroutines.
+func withStacks(states []HandlerState) []HandlerState {
+	stacks := goroutineStacks()
+	for i := range states {
+		states[i].Stack = stacks[states[i].GoroutineID]
+	}
+	return states
+}
+
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, stackBufferSize)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	stacks := make(map[int64]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if id, ok := parseGoroutineID(stack); ok {
+			stacks[id] = string(stack)
+		}
+	}
+	return stacks
+}
+
+func currentGoroutineID() int64 {
+	var buf [64]byte
+	id, _ := parseGoroutineID(buf[:runtime.Stack(buf[:], false)])
+	return id
+}
+
+// Parse a header line of a stack like "goroutine 18 [running]:".
+func parseGoroutineID(stack []byte) (int64, bool) {
+	fields := bytes.Fields(stack)
+	if len(fields) < 2 || string(fields[0]) != "goroutine" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,92 @@
+package goseq
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoroutineID(t *testing.T) {
+	id, ok := parseGoroutineID([]byte("goroutine 18 [running]:\nmain.main()"))
+	if !ok || id != 18 {
+		t.Error("parseGoroutineID should parse a stack header. id:", id)
+	}
+	if _, ok := parseGoroutineID([]byte("main.main()")); ok {
+		t.Error("parseGoroutineID should fail for a wrong header.")
+	}
+}
+
+func TestCurrentGoroutineID(t *testing.T) {
+	if currentGoroutineID() <= 0 {
+		t.Error("currentGoroutineID should return a positive id.")
+	}
+}
+
+func TestWatchdogCheck(t *testing.T) {
+	count := 0
+	cursor := NewSequence()
+	group := newHandlerGroup(sampleToIndexFunc)
+	wd := newWatchdog(cursor, time.Second, func(report StallReport) {
+		count++
+	})
+	now := time.Now()
+	wd.check(group, now)
+	wd.check(group, now.Add(2*time.Second))
+	if count != 0 {
+		t.Error("watchdog should not report a group without pending ids.")
+	}
+	cursor.Set(1)
+	wd.check(group, now.Add(2*time.Second))
+	wd.check(group, now.Add(3*time.Second))
+	if count != 1 {
+		t.Error("watchdog should report a stalled group once. count:", count)
+	}
+	group.lastProcessedID.Set(0)
+	wd.check(group, now.Add(4*time.Second))
+	if count != 1 {
+		t.Error("watchdog should reset a timer after progress.")
+	}
+	wd.check(group, now.Add(5*time.Second))
+	if count != 2 {
+		t.Error("watchdog should report a stall again. count:", count)
+	}
+	group.lastProcessedID.Set(1)
+	wd.check(group, now.Add(6*time.Second))
+	wd.check(group, now.Add(20*time.Second))
+	cursor.Set(2)
+	wd.check(group, now.Add(20*time.Second+time.Millisecond))
+	if count != 2 {
+		t.Error("watchdog should not count an idle time as a stall. count:", count)
+	}
+	wd.check(group, now.Add(21*time.Second+time.Millisecond))
+	if count != 3 {
+		t.Error("watchdog should report a stall after a new id. count:", count)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	reports := make(chan StallReport, 1)
+	release := make(chan bool)
+	tm := NewTaskManager(8)
+	tm.AddHandler(func(id SequenceID, index int) {
+		<-release
+	})
+	tm.Watch(5*time.Millisecond, func(report StallReport) {
+		reports <- report
+	})
+	tm.Start()
+	tm.Put(nil)
+	report := <-reports
+	close(release)
+	tm.Stop()
+
+	if report.Cursor != 0 || report.LastProcessedID != initialSequenceValue {
+		t.Error("StallReport should have a cursor and LastProcessedID.", report.Cursor, report.LastProcessedID)
+	}
+	if len(report.Handlers) != 1 || !report.Handlers[0].Running || report.Handlers[0].ID != 0 {
+		t.Error("StallReport should show which SequenceID a handler is running.")
+	}
+	if !strings.Contains(report.Handlers[0].Stack, "TestWatch") {
+		t.Error("StallReport should have a stack of a handler goroutine.", report.Handlers[0].Stack)
+	}
+}